@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"net"
 	"os"
+	"strconv"
 	"sync"
-	"time"
 	"sync/atomic"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	syslog "github.com/jtarchie/syslog/pkg/log"
 )
 
 var failedMessages int32
@@ -17,7 +20,7 @@ var failedMessages int32
 func main() {
 	workers := flag.Int("num-workers", 1, "number of workers to generate messages")
 	numMessages := flag.Int("num-messages", 1000, "number of messages to send")
-	uri := flag.String("uri", "", "the endpoint to connect to (udp://)")
+	uri := flag.String("uri", "", "the endpoint to connect to (udp://, tcp://, tls://, unix:// or unixgram://)")
 	flag.Parse()
 
 	wait := sync.WaitGroup{}
@@ -32,7 +35,7 @@ func main() {
 
 	wait.Wait()
 
-	fmt.Printf("failed sending %d messages", failedMessages	)
+	fmt.Printf("failed sending %d messages", failedMessages)
 }
 
 func setupWorker(worker int, numMessages *int, uri *string) {
@@ -47,23 +50,20 @@ func setupWorker(worker int, numMessages *int, uri *string) {
 	}
 }
 
-func sendMessage(conn net.Conn, worker int, j int) error {
-	_, err := fmt.Fprintf(
-		conn,
-		"<%d>%d %s worker-%d.example.com %s %d %d - This is message %d from worker %d\n",
-		rand.Int31n(192), //priority 0-191
-		1,                //version
-		time.Now().Format("2006-01-01T15:04:05.000Z"),
-		worker,      //host
-		"flood",     //app name
-		os.Getpid(), //proc id
-		j,           //log id
-		j, worker,
-	)
-	return err
+func sendMessage(conn *client.Client, worker int, j int) error {
+	message := syslog.New()
+	message.SetPriority(int(rand.Int31n(192))) //priority 0-191
+	message.SetTimestamp(time.Now().UTC())
+	message.SetHostname(fmt.Sprintf("worker-%d.example.com", worker))
+	message.SetAppname("flood")
+	message.SetProcID(strconv.Itoa(os.Getpid()))
+	message.SetMsgID(strconv.Itoa(j))
+	message.SetMessage(fmt.Sprintf("This is message %d from worker %d", j, worker))
+	return conn.Send(message)
 }
-func createConnection(uri *string) net.Conn {
-	conn, err := net.Dial("udp", *uri)
+
+func createConnection(uri *string) *client.Client {
+	conn, err := client.Dial(*uri, client.Options{})
 	if err != nil {
 		log.Fatalf("cannot connect: %s", err)
 	}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jtarchie/syslog/pkg/metrics"
+	"github.com/jtarchie/syslog/pkg/transports"
+	"github.com/jtarchie/syslog/pkg/writers/relay"
+)
+
+// relay receives syslog over UDP on an edge host and forwards it to a
+// central collector, queueing on disk while the collector is unreachable.
+func main() {
+	port := flag.Int("port", 514, "UDP port to receive syslog on")
+	queue := flag.String("queue", "relay.db", "file to queue messages in until they are forwarded")
+	forward := flag.String("forward", "", "collector to forward to, e.g. relp://collector:2514 or tls://collector:6514")
+	metricsAddr := flag.String("metrics-addr", "localhost:8089", "address to serve Prometheus metrics on, at /metrics")
+	unacknowledged := flag.Bool("unacknowledged", false, "allow forwarding over tcp, tls or udp, which lose messages the collector had yet to read when it disconnects")
+	flag.Parse()
+
+	if *forward == "" {
+		log.Fatalf("usage: relay -forward uri [-port port] [-queue path] [-unacknowledged]")
+	}
+
+	registry := metrics.NewRegistry()
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		log.Fatalf("Could not start metrics: %s", http.ListenAndServe(*metricsAddr, mux))
+	}()
+
+	writer, err := relay.New(*queue, *forward, relay.Options{
+		Metrics:        registry,
+		Unacknowledged: *unacknowledged,
+	})
+	if err != nil {
+		log.Fatalf("Could not open relay: %s", err)
+	}
+
+	server, err := transports.NewUDPServer(*port, writer, transports.Options{Metrics: registry})
+	if err != nil {
+		log.Fatalf("Could not start server: %s", err)
+	}
+
+	// stopping the server hands what it has received to the relay, which
+	// then finishes its in-flight batch
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	err = server.Start()
+	closeErr := writer.Close()
+	if err != nil {
+		log.Fatalf("Could not start server: %s", err)
+	}
+	if closeErr != nil {
+		log.Fatalf("Could not close relay: %s", closeErr)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+// replay re-runs dead letters through the parser, usually after a parser
+// fix has shipped. Messages that now parse are printed, or forwarded to a
+// collector with -uri; those that still fail can be kept with -failed.
+func main() {
+	uri := flag.String("uri", "", "forward messages that parse to this UDP endpoint (host:port) instead of printing them")
+	failedPath := flag.String("failed", "", "write dead letters that still fail to parse to this file")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatalf("usage: replay [-uri host:port] [-failed path] dead-letter-file...")
+	}
+
+	var conn net.Conn
+	if *uri != "" {
+		var err error
+		conn, err = net.Dial("udp", *uri)
+		if err != nil {
+			log.Fatalf("cannot connect: %s", err)
+		}
+		defer conn.Close()
+	}
+
+	var failed *transports.DeadLetterWriter
+	if *failedPath != "" {
+		file, err := os.OpenFile(*failedPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("cannot open failed file: %s", err)
+		}
+		defer file.Close()
+		failed = transports.NewDeadLetterWriter(file)
+	}
+
+	parsed, unparsed := 0, 0
+	for _, path := range flag.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("cannot open dead letters: %s", err)
+		}
+
+		err = transports.ReadDeadLetters(file, func(letter transports.DeadLetter) error {
+			l, _, err := syslog.Parse(letter.Payload)
+			if err != nil {
+				unparsed++
+				if failed == nil {
+					return nil
+				}
+				letter.Error = err.Error()
+				return failed.WriteDeadLetter(letter)
+			}
+
+			parsed++
+			if conn == nil {
+				fmt.Println(l.String())
+				return nil
+			}
+			_, err = conn.Write(letter.Payload)
+			return err
+		})
+		file.Close()
+		if err != nil {
+			log.Fatalf("cannot replay %s: %s", path, err)
+		}
+	}
+
+	log.Printf("replayed %d messages, %d still failed to parse", parsed, unparsed)
+}
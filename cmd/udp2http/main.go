@@ -1,23 +1,136 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/jtarchie/syslog/pkg/metrics"
 	"github.com/jtarchie/syslog/pkg/transports"
-	"github.com/jtarchie/syslog/pkg/writers/web"
+	"github.com/jtarchie/syslog/pkg/writers"
+	web "github.com/jtarchie/syslog/pkg/writers/web"
 )
 
 func main() {
+	deadLetters := flag.String("dead-letters", "", "file to record messages that fail to parse")
+	httpPort := flag.Int("http-port", 0, "port to accept syslog over HTTP POST on, disabled when 0")
+	metricsAddr := flag.String("metrics-addr", "localhost:8089", "address to serve Prometheus metrics on, at /metrics")
+	rules := flag.String("rules", "", "JSON file of rewrite rules to apply before storing messages")
+	dataDir := flag.String("data-dir", "data", "directory to keep the message store and search index in")
+	retainAge := flag.Duration("retain-age", 0, "delete stored messages older than this, kept forever when 0")
+	retainBytes := flag.Int64("retain-bytes", 0, "delete the oldest stored messages beyond this many bytes, unlimited when 0")
+	retainCount := flag.Int("retain-count", 0, "delete the oldest stored messages beyond this many, unlimited when 0")
+	redact := flag.Bool("redact", false, "redact card numbers, tokens, emails and IPs before storing messages")
+	redactKey := flag.String("redact-key", "", "replace redacted values with an HMAC keyed by this, instead of masking them")
+	auth := flag.String("auth", "", "JSON file of tokens, users, proxy headers and roles to require for the search UI and API")
+	flag.Parse()
+
 	log.Println("starting servers")
-	writer := writers.NewServer(8081)
+	registry := metrics.NewRegistry()
 	go func() {
-		log.Fatalf("Could not start writer: %s", writer.Start())
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		log.Fatalf("Could not start metrics: %s", http.ListenAndServe(*metricsAddr, mux))
 	}()
 
-	server, err := transports.NewUDPServer(8088, writer)
+	webOptions := web.Options{DataDir: *dataDir, Metrics: registry}
+	if *retainAge > 0 || *retainBytes > 0 || *retainCount > 0 {
+		webOptions.Retention = []web.Retention{{
+			MaxAge:   *retainAge,
+			MaxBytes: *retainBytes,
+			MaxCount: *retainCount,
+		}}
+	}
+	if *auth != "" {
+		var err error
+		webOptions.Auth, err = web.LoadAuth(*auth)
+		if err != nil {
+			log.Fatalf("Could not load auth: %s", err)
+		}
+	}
+	server, err := web.NewServer(8081, webOptions)
+	if err != nil {
+		log.Fatalf("Could not open writer: %s", err)
+	}
+	go func() {
+		err := server.Start()
+		if err != nil {
+			log.Fatalf("Could not start writer: %s", err)
+		}
+	}()
+
+	var writer transports.Writer = server
+	if *redact {
+		options := writers.RedactOptions{}
+		if *redactKey != "" {
+			options.Mode = writers.RedactHash
+			options.Key = []byte(*redactKey)
+		}
+		writer = writers.NewRedactor(writer, options)
+	}
+	if *rules != "" {
+		chain, err := writers.LoadChain(*rules)
+		if err != nil {
+			log.Fatalf("Could not load rules: %s", err)
+		}
+		writer = writers.NewRewriter(writer, chain)
+	}
+
+	options := transports.Options{Metrics: registry}
+	if *deadLetters != "" {
+		file, err := transports.NewDeadLetterFile(*deadLetters, 100*1024*1024, 5)
+		if err != nil {
+			log.Fatalf("Could not open dead letters: %s", err)
+		}
+		defer file.Close()
+		options.DeadLetters = file
+	}
+
+	var httpServer *transports.HTTPServer
+	httpStopped := make(chan struct{})
+	if *httpPort > 0 {
+		httpServer, err = transports.NewHTTPServer(*httpPort, writer, options)
+		if err != nil {
+			log.Fatalf("Could not start HTTP server: %s", err)
+		}
+		go func() {
+			defer close(httpStopped)
+			err := httpServer.Start()
+			if err != nil {
+				log.Fatalf("Could not start HTTP server: %s", err)
+			}
+		}()
+	} else {
+		close(httpStopped)
+	}
+
+	udpServer, err := transports.NewUDPServer(8088, writer, options)
 	if err != nil {
 		log.Fatalf("Could not start server: %s", err)
 	}
 
-	server.Start()
+	// stopping the servers hands what they have received to the writers,
+	// then the store and index are closed cleanly
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if httpServer != nil {
+			httpServer.Close()
+		}
+		udpServer.Close()
+	}()
+
+	err = udpServer.Start()
+	<-httpStopped
+	closeErr := server.Close()
+	if err != nil {
+		log.Fatalf("Could not start server: %s", err)
+	}
+	if closeErr != nil {
+		log.Fatalf("Could not close writer: %s", closeErr)
+	}
 }
@@ -0,0 +1,323 @@
+// Package client sends syslog messages over UDP, TCP, TLS and Unix sockets.
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// Framing is how messages are delimited on stream transports (RFC 6587).
+type Framing int
+
+const (
+	// OctetCounting prefixes each message with its length and a space.
+	OctetCounting Framing = iota
+	// NonTransparent ends each message with a line feed.
+	NonTransparent
+)
+
+const (
+	defaultMaxDatagramSize = 2048
+	defaultDialTimeout     = 10 * time.Second
+	defaultMinBackoff      = 100 * time.Millisecond
+	defaultMaxBackoff      = 10 * time.Second
+	defaultMaxAttempts     = 5
+)
+
+type Options struct {
+	// Framing is used for tcp, tls and unix. Defaults to OctetCounting.
+	Framing Framing
+	// TLSConfig is used for tls. Defaults to verifying against the host.
+	TLSConfig *tls.Config
+	// MaxDatagramSize is where messages sent over udp and unixgram are
+	// truncated. Defaults to 2048 bytes, the size RFC 5426 says receivers
+	// should accept.
+	MaxDatagramSize int
+	DialTimeout     time.Duration
+	// WriteTimeout bounds each write and, over relp, the wait for its
+	// acknowledgement. Zero means no timeout.
+	WriteTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait between reconnects, which
+	// doubles after each failure.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is how many times a message is tried, reconnecting in
+	// between, before Send gives up.
+	MaxAttempts int
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxDatagramSize <= 0 {
+		o.MaxDatagramSize = defaultMaxDatagramSize
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = max(defaultMaxBackoff, o.MinBackoff)
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultMaxAttempts
+	}
+	return o
+}
+
+// Client sends messages to a single endpoint, connecting lazily and
+// reconnecting with backoff after failures. It is safe for concurrent use.
+type Client struct {
+	network  string
+	address  string
+	datagram bool
+	relp     bool
+	options  Options
+
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	txnr    int
+	backoff time.Duration
+	closed  bool
+}
+
+// Dial parses uri and connects to it. Supported schemes are udp, tcp, tls,
+// relp, relp+tls, unix (stream) and unixgram, e.g. "tcp://localhost:514" or
+// "unixgram:///dev/log". A uri without a scheme is treated as udp.
+func Dial(uri string, options Options) (*Client, error) {
+	c, err := New(uri, options)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// New is like Dial, but doesn't connect until the first message is sent.
+func New(uri string, options Options) (*Client, error) {
+	if !strings.Contains(uri, "://") {
+		uri = "udp://" + uri
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri %q: %s", uri, err)
+	}
+
+	c := &Client{
+		network: parsed.Scheme,
+		address: parsed.Host,
+		options: options.withDefaults(),
+	}
+	switch parsed.Scheme {
+	case "udp", "udp4", "udp6":
+		c.datagram = true
+	case "tcp", "tcp4", "tcp6", "tls":
+	case "relp":
+		c.network = "tcp"
+		c.relp = true
+	case "relp+tls":
+		c.network = "tls"
+		c.relp = true
+	case "unix":
+		c.address = parsed.Path
+	case "unixgram":
+		c.address = parsed.Path
+		c.datagram = true
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if c.address == "" {
+		return nil, fmt.Errorf("invalid uri %q: missing address", uri)
+	}
+
+	return c, nil
+}
+
+// Acknowledged reports whether Write returns only once the server has
+// acknowledged the message, which is only the case over RELP. Otherwise it
+// returns once the message is handed to the operating system, and a
+// message the server never read is lost when it disconnects.
+func (c *Client) Acknowledged() bool {
+	return c.relp
+}
+
+// Send serializes l with Log.String and sends it.
+func (c *Client) Send(l *syslog.Log) error {
+	_, err := c.Write([]byte(l.String()))
+	return err
+}
+
+// Write sends p as a single, already serialized, message. Over datagram
+// transports it is truncated to MaxDatagramSize. Over RELP it returns once
+// the server has acknowledged the message.
+func (c *Client) Write(p []byte) (int, error) {
+	frame := c.frame(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < c.options.MaxAttempts; attempt++ {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+
+		if c.conn == nil {
+			if attempt > 0 {
+				c.wait()
+			}
+			err = c.connect()
+			if err != nil {
+				continue
+			}
+		}
+
+		if c.relp {
+			_, err = c.command("syslog", p)
+		} else {
+			err = c.write(frame)
+		}
+		if err == nil {
+			c.backoff = 0
+			return len(p), nil
+		}
+
+		c.disconnect()
+	}
+
+	return 0, fmt.Errorf("could not send after %d attempts: %w", c.options.MaxAttempts, err)
+}
+
+func (c *Client) frame(p []byte) []byte {
+	if c.relp {
+		return nil
+	}
+
+	if c.datagram {
+		return truncate(p, c.options.MaxDatagramSize)
+	}
+
+	if c.options.Framing == NonTransparent {
+		frame := make([]byte, 0, len(p)+1)
+		frame = append(frame, p...)
+		return append(frame, '\n')
+	}
+
+	frame := make([]byte, 0, len(p)+8)
+	frame = strconv.AppendInt(frame, int64(len(p)), 10)
+	frame = append(frame, ' ')
+	return append(frame, p...)
+}
+
+func (c *Client) write(frame []byte) error {
+	if c.options.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// wait sleeps for the current backoff and doubles it. It must be called
+// with the lock held, which also holds back other senders.
+func (c *Client) wait() {
+	if c.backoff == 0 {
+		c.backoff = c.options.MinBackoff
+	} else {
+		c.backoff = min(2*c.backoff, c.options.MaxBackoff)
+	}
+	time.Sleep(c.backoff)
+}
+
+func (c *Client) connect() error {
+	dialer := &net.Dialer{Timeout: c.options.DialTimeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.network == "tls" {
+		config := c.options.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, config)
+	} else {
+		conn, err = dialer.Dial(c.network, c.address)
+	}
+	if err != nil {
+		return fmt.Errorf("could not connect to %s://%s: %w", c.network, c.address, err)
+	}
+
+	c.conn = conn
+	if c.relp {
+		err = c.open()
+		if err != nil {
+			c.disconnect()
+			return fmt.Errorf("could not open relp session with %s: %w", c.address, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) disconnect() {
+	c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	if c.relp {
+		_, _ = c.command("close", nil)
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// truncate cuts p to size bytes without splitting a UTF-8 sequence.
+func truncate(p []byte, size int) []byte {
+	if len(p) <= size {
+		return p
+	}
+
+	p = p[:size]
+	for i := 0; i < utf8.UTFMax-1 && len(p) > 0; i++ {
+		r, n := utf8.DecodeLastRune(p)
+		if r != utf8.RuneError || n != 1 {
+			break
+		}
+		p = p[:len(p)-1]
+	}
+	return p
+}
@@ -0,0 +1,371 @@
+package client_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type SpyWriter struct {
+	logs []*syslog.Log
+	mu   sync.Mutex
+}
+
+func (s *SpyWriter) Write(log *syslog.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logs = append(s.logs, log)
+	return nil
+}
+
+func (s *SpyWriter) Logs() []*syslog.Log {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logs
+}
+
+func message(text string) *syslog.Log {
+	log := syslog.New()
+	log.SetFacilitySeverity(4, 2)
+	log.SetTimestamp(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC))
+	log.SetHostname("mymachine.example.com")
+	log.SetAppname("su")
+	log.SetMessage(text)
+	return log
+}
+
+// streamListener accepts connections and collects everything written to
+// them, one string per connection.
+type streamListener struct {
+	net.Listener
+	mu       sync.Mutex
+	received []*strings.Builder
+	conns    []net.Conn
+}
+
+func listen(listener net.Listener) *streamListener {
+	s := &streamListener{Listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			builder := &strings.Builder{}
+			s.mu.Lock()
+			s.received = append(s.received, builder)
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			go func() {
+				buffer := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buffer)
+					s.mu.Lock()
+					builder.Write(buffer[:n])
+					s.mu.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	DeferCleanup(listener.Close)
+	return s
+}
+
+func (s *streamListener) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := []string{}
+	for _, builder := range s.received {
+		values = append(values, builder.String())
+	}
+	return values
+}
+
+func (s *streamListener) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+var _ = Describe("Client", func() {
+	const rendered = "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - - - hello"
+	octetCounted := fmt.Sprintf("%d %s", len(rendered), rendered)
+
+	It("sends over UDP to the UDP server", func() {
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{BatchInterval: time.Millisecond})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		c, err := client.Dial("udp://"+server.Addr().String(), client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		Eventually(writer.Logs).Should(HaveLen(1))
+		Expect(writer.Logs()[0].String()).To(Equal(rendered))
+	})
+
+	It("treats addresses without a scheme as UDP", func() {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		c, err := client.Dial(listener.LocalAddr().String(), client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		buffer := make([]byte, 4096)
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buffer[:n])).To(Equal(rendered))
+	})
+
+	It("truncates datagrams to the maximum size", func() {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		c, err := client.Dial("udp://"+listener.LocalAddr().String(), client.Options{MaxDatagramSize: 100})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		// the cut at 100 bytes lands inside a multi-byte rune
+		long := strings.Repeat("a", 100-len(rendered)+len("hello")-1) + "ééé"
+		Expect(c.Send(message(long))).To(Succeed())
+
+		buffer := make([]byte, 4096)
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(99))
+		Expect(strings.ToValidUTF8(string(buffer[:n]), "?")).To(Equal(string(buffer[:n])))
+
+		parsed, _, err := syslog.Parse(buffer[:n])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Hostname()).To(Equal("mymachine.example.com"))
+	})
+
+	It("sends over TCP with octet counting", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		c, err := client.Dial("tcp://"+listener.Addr().String(), client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		Eventually(server.Received).Should(Equal([]string{
+			octetCounted + octetCounted,
+		}))
+
+		parsed, offset, err := syslog.Parse([]byte(server.Received()[0]))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(offset).To(Equal(len(octetCounted)))
+		Expect(parsed.String()).To(Equal(rendered))
+	})
+
+	It("sends over TCP with line feeds", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		c, err := client.Dial("tcp://"+listener.Addr().String(), client.Options{Framing: client.NonTransparent})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		Eventually(server.Received).Should(Equal([]string{rendered + "\n"}))
+	})
+
+	It("sends over TLS", func() {
+		certificate := selfSigned()
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(certificate.Leaf)
+		c, err := client.Dial("tls://"+listener.Addr().String(), client.Options{
+			TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		Eventually(server.Received).Should(Equal([]string{octetCounted}))
+	})
+
+	It("refuses TLS servers it can't verify", func() {
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSigned()}})
+		Expect(err).ToNot(HaveOccurred())
+		listen(listener)
+
+		_, err = client.Dial("tls://"+listener.Addr().String(), client.Options{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends over unix stream sockets", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "syslog.sock")
+		listener, err := net.Listen("unix", path)
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		c, err := client.Dial("unix://"+path, client.Options{Framing: client.NonTransparent})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		Eventually(server.Received).Should(Equal([]string{rendered + "\n"}))
+	})
+
+	It("sends over unix datagram sockets", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "log")
+		listener, err := net.ListenPacket("unixgram", path)
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		c, err := client.Dial("unixgram://"+path, client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(c.Send(message("hello"))).To(Succeed())
+
+		buffer := make([]byte, 4096)
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buffer[:n])).To(Equal(rendered))
+	})
+
+	It("reconnects when the connection drops", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		c, err := client.Dial("tcp://"+listener.Addr().String(), client.Options{
+			Framing:    client.NonTransparent,
+			MinBackoff: time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+		Eventually(server.Received).Should(HaveLen(1))
+
+		server.DropConnections()
+
+		// the first write after the peer closes can still succeed locally
+		Eventually(func() []string {
+			Expect(c.Send(message("hello"))).To(Succeed())
+			return server.Received()
+		}).Should(ContainElement(rendered + "\n"))
+		Expect(len(server.Received())).To(BeNumerically(">=", 2))
+	})
+
+	It("gives up after the maximum attempts", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		address := listener.Addr().String()
+		listener.Close()
+
+		c, err := client.New("tcp://"+address, client.Options{
+			MinBackoff:  time.Millisecond,
+			MaxAttempts: 3,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		err = c.Send(message("hello"))
+		Expect(err).To(MatchError(ContainSubstring("could not send after 3 attempts")))
+	})
+
+	It("rejects unsupported schemes", func() {
+		_, err := client.New("http://localhost:514", client.Options{})
+		Expect(err).To(MatchError(ContainSubstring("unsupported scheme")))
+	})
+
+	It("can be used as an io.Writer", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := listen(listener)
+
+		c, err := client.Dial("tcp://"+listener.Addr().String(), client.Options{Framing: client.NonTransparent})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		var writer io.Writer = c
+		_, err = io.WriteString(writer, rendered)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() string {
+			received := server.Received()
+			if len(received) == 0 {
+				return ""
+			}
+			line, _ := bufio.NewReader(strings.NewReader(received[0])).ReadString('\n')
+			return line
+		}).Should(Equal(rendered + "\n"))
+	})
+})
+
+func selfSigned() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	leaf, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// DefaultSDID is the SD-ID attributes are written under. 32473 is the
+// enterprise number RFC 5612 reserves for documentation.
+const DefaultSDID = "slog@32473"
+
+const facilityUser = 1
+
+type HandlerOptions struct {
+	// Level is the minimum level handled. Defaults to slog.LevelInfo.
+	Level slog.Leveler
+	// SDID is the SD-ID of the element holding attributes. Defaults to
+	// DefaultSDID.
+	SDID string
+	// Facility defaults to user (1).
+	Facility int
+	// Hostname, Appname and ProcID default to os.Hostname, the base name of
+	// the executable and the process id. They are sanitized to what RFC
+	// 5424 allows, as the log's setters do.
+	Hostname string
+	Appname  string
+	ProcID   string
+}
+
+func (o HandlerOptions) withDefaults() HandlerOptions {
+	if o.Level == nil {
+		o.Level = slog.LevelInfo
+	}
+	if o.SDID == "" {
+		o.SDID = DefaultSDID
+	}
+	if o.Facility == 0 {
+		o.Facility = facilityUser
+	}
+	if o.Hostname == "" {
+		o.Hostname, _ = os.Hostname()
+	}
+	if o.Appname == "" && len(os.Args) > 0 {
+		o.Appname = filepath.Base(os.Args[0])
+	}
+	if o.ProcID == "" {
+		o.ProcID = strconv.Itoa(os.Getpid())
+	}
+	o.Hostname = syslog.SanitizeHostname(o.Hostname)
+	o.Appname = syslog.SanitizeAppname(o.Appname)
+	o.ProcID = syslog.SanitizeProcID(o.ProcID)
+	o.SDID = syslog.SanitizeSDName(o.SDID)
+	return o
+}
+
+// Handler is a slog.Handler that emits RFC 5424 messages. Attributes become
+// SD-PARAMs, with groups joined to the name by dots.
+type Handler struct {
+	writer  io.Writer
+	mutex   *sync.Mutex
+	options HandlerOptions
+	prefix  string
+	params  []syslog.Property
+}
+
+var _ slog.Handler = &Handler{}
+
+// NewHandler writes to w. A *Client sends each record as one message; any
+// other writer gets one message per line.
+func NewHandler(w io.Writer, options HandlerOptions) *Handler {
+	return &Handler{
+		writer:  w,
+		mutex:   &sync.Mutex{},
+		options: options.withDefaults(),
+	}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.options.Level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	l := syslog.New()
+	l.SetFacilitySeverity(h.options.Facility, Severity(record.Level))
+	if !record.Time.IsZero() {
+		l.SetTimestamp(record.Time)
+	}
+	l.SetHostname(h.options.Hostname)
+	l.SetAppname(h.options.Appname)
+	l.SetProcID(h.options.ProcID)
+	l.SetMessage(record.Message)
+
+	params := append([]syslog.Property{}, h.params...)
+	record.Attrs(func(attr slog.Attr) bool {
+		params = appendAttr(params, h.prefix, attr)
+		return true
+	})
+	if len(params) > 0 {
+		l.AddStructureElement(h.options.SDID, params...)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if c, ok := h.writer.(*Client); ok {
+		return c.Send(l)
+	}
+
+	_, err := io.WriteString(h.writer, l.String()+"\n")
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	clone := *h
+	clone.params = append([]syslog.Property{}, h.params...)
+	for _, attr := range attrs {
+		clone.params = appendAttr(clone.params, h.prefix, attr)
+	}
+	return &clone
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+// Severity maps a slog level to a syslog severity. Levels between the
+// named ones fall to the less severe side, so Info+2 is notice and
+// anything past Error+12 is emergency.
+func Severity(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 7
+	case level < slog.LevelInfo+2:
+		return 6
+	case level < slog.LevelWarn:
+		return 5
+	case level < slog.LevelError:
+		return 4
+	case level < slog.LevelError+4:
+		return 3
+	case level < slog.LevelError+8:
+		return 2
+	case level < slog.LevelError+12:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func appendAttr(params []syslog.Property, prefix string, attr slog.Attr) []syslog.Property {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return params
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, child := range attr.Value.Group() {
+			params = appendAttr(params, prefix, child)
+		}
+		return params
+	}
+
+	return append(params, syslog.Property{
+		Key:   syslog.SanitizeSDName(prefix + attr.Key),
+		Value: attr.Value.String(),
+	})
+}
@@ -0,0 +1,146 @@
+package client_test
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func parseLines(buffer *bytes.Buffer) []*syslog.Log {
+	var logs []*syslog.Log
+	for _, line := range strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n") {
+		log, _, err := syslog.Parse([]byte(line))
+		Expect(err).ToNot(HaveOccurred())
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+var _ = Describe("Handler", func() {
+	It("writes one RFC 5424 message per line", func() {
+		buffer := &bytes.Buffer{}
+		logger := slog.New(client.NewHandler(buffer, client.HandlerOptions{
+			Hostname: "host",
+			Appname:  "app",
+			ProcID:   "42",
+		}))
+
+		logger.Info("hello", "user", "bob", "count", 3)
+
+		logs := parseLines(buffer)
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Facility()).To(Equal(1))
+		Expect(logs[0].Severity()).To(Equal(6))
+		Expect(logs[0].Hostname()).To(Equal("host"))
+		Expect(logs[0].Appname()).To(Equal("app"))
+		Expect(logs[0].ProcID()).To(Equal("42"))
+		Expect(logs[0].Message()).To(Equal("hello"))
+		Expect(logs[0].Timestamp()).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(logs[0].StructureData()).To(HaveLen(1))
+		Expect(logs[0].StructureData()[0].ID()).To(Equal(client.DefaultSDID))
+		Expect(logs[0].StructureData()[0].Properties()).To(Equal([]syslog.Property{
+			{Key: "user", Value: "bob"},
+			{Key: "count", Value: "3"},
+		}))
+	})
+
+	It("fills in the host, executable and process id", func() {
+		buffer := &bytes.Buffer{}
+		slog.New(client.NewHandler(buffer, client.HandlerOptions{})).Info("hello")
+
+		hostname, _ := os.Hostname()
+		log := parseLines(buffer)[0]
+		Expect(log.Hostname()).To(Equal(hostname))
+		Expect(log.Appname()).ToNot(BeEmpty())
+		Expect(log.ProcID()).To(Equal(strconv.Itoa(os.Getpid())))
+		Expect(log.StructureData()).To(BeEmpty())
+	})
+
+	It("makes the default appname valid", func() {
+		args := os.Args
+		DeferCleanup(func() { os.Args = args })
+		os.Args = append([]string{"/opt/bin/my app " + strings.Repeat("x", 60)}, args[1:]...)
+
+		buffer := &bytes.Buffer{}
+		slog.New(client.NewHandler(buffer, client.HandlerOptions{})).Info("hello")
+
+		log := parseLines(buffer)[0]
+		Expect(log.Appname()).To(Equal("my_app_" + strings.Repeat("x", 41)))
+		Expect(syslog.ValidateAppname(log.Appname())).To(Succeed())
+	})
+
+	It("names params by group under the configured SD-ID", func() {
+		buffer := &bytes.Buffer{}
+		logger := slog.New(client.NewHandler(buffer, client.HandlerOptions{SDID: "app@32473"}))
+
+		logger.
+			With("service", "billing").
+			WithGroup("request").
+			With("id", "abc").
+			Info("done", slog.Group("user", "name", "bob"), "weird key=]", `a "quoted" ] value`)
+
+		element := parseLines(buffer)[0].StructureData()[0]
+		Expect(element.ID()).To(Equal("app@32473"))
+		Expect(element.Properties()).To(Equal([]syslog.Property{
+			{Key: "service", Value: "billing"},
+			{Key: "request.id", Value: "abc"},
+			{Key: "request.user.name", Value: "bob"},
+			{Key: "request.weird_key__", Value: `a "quoted" ] value`},
+		}))
+	})
+
+	DescribeTable("maps levels to severities", func(level slog.Level, severity int) {
+		Expect(client.Severity(level)).To(Equal(severity))
+	},
+		Entry("debug", slog.LevelDebug, 7),
+		Entry("info", slog.LevelInfo, 6),
+		Entry("notice", slog.LevelInfo+2, 5),
+		Entry("warn", slog.LevelWarn, 4),
+		Entry("error", slog.LevelError, 3),
+		Entry("critical", slog.LevelError+4, 2),
+		Entry("alert", slog.LevelError+8, 1),
+		Entry("emergency", slog.LevelError+12, 0),
+	)
+
+	It("drops records below the level", func() {
+		buffer := &bytes.Buffer{}
+		logger := slog.New(client.NewHandler(buffer, client.HandlerOptions{Level: slog.LevelWarn}))
+
+		logger.Info("quiet")
+		logger.Warn("loud")
+
+		logs := parseLines(buffer)
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Message()).To(Equal("loud"))
+		Expect(logs[0].Severity()).To(Equal(4))
+	})
+
+	It("sends through a client", func() {
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{BatchInterval: time.Millisecond})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		c, err := client.Dial("udp://"+server.Addr().String(), client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		slog.New(client.NewHandler(c, client.HandlerOptions{})).Error("failed", "code", 500)
+
+		Eventually(writer.Logs).Should(HaveLen(1))
+		log := writer.Logs()[0]
+		Expect(log.Severity()).To(Equal(3))
+		Expect(log.Message()).To(Equal("failed"))
+		Expect(log.StructureData()[0].Properties()).To(Equal([]syslog.Property{{Key: "code", Value: "500"}}))
+	})
+})
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// relpOffers is what the client asks for when opening a RELP session.
+const relpOffers = "relp_version=0\nrelp_software=github.com/jtarchie/syslog\ncommands=syslog"
+
+// maxRELPFrame bounds the data of a response read from the server.
+const maxRELPFrame = 128 * 1024
+
+// open starts a RELP session on a fresh connection.
+func (c *Client) open() error {
+	c.reader = bufio.NewReader(c.conn)
+	c.txnr = 0
+
+	_, err := c.command("open", []byte(relpOffers))
+	return err
+}
+
+// command sends a RELP command and waits for its response, returning the
+// response data after the status code. A status other than 200 is an error.
+func (c *Client) command(name string, data []byte) ([]byte, error) {
+	c.txnr++
+	if c.txnr > 999999999 {
+		c.txnr = 1
+	}
+	txnr := c.txnr
+
+	frame := make([]byte, 0, len(data)+32)
+	frame = strconv.AppendInt(frame, int64(txnr), 10)
+	frame = append(frame, ' ')
+	frame = append(frame, name...)
+	frame = append(frame, ' ')
+	frame = strconv.AppendInt(frame, int64(len(data)), 10)
+	if len(data) > 0 {
+		frame = append(frame, ' ')
+		frame = append(frame, data...)
+	}
+	frame = append(frame, '\n')
+
+	err := c.write(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.WriteTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.options.WriteTimeout))
+	}
+	for {
+		rsp, command, body, err := readRELPFrame(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if command == "serverclose" {
+			return nil, fmt.Errorf("relp server closed the session")
+		}
+		if command != "rsp" || rsp != txnr {
+			continue
+		}
+
+		status, rest, _ := bytes.Cut(body, []byte(" "))
+		if string(status) != "200" {
+			return nil, fmt.Errorf("relp %s failed: %s", name, body)
+		}
+		return rest, nil
+	}
+}
+
+// readRELPFrame reads "TXNR SP COMMAND SP DATALEN [SP DATA] LF".
+func readRELPFrame(r *bufio.Reader) (int, string, []byte, error) {
+	field := func(delimiters string) (string, byte, error) {
+		var buffer []byte
+		for len(buffer) <= 32 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return "", 0, err
+			}
+			if bytes.IndexByte([]byte(delimiters), b) >= 0 {
+				return string(buffer), b, nil
+			}
+			buffer = append(buffer, b)
+		}
+		return "", 0, fmt.Errorf("invalid relp frame header")
+	}
+
+	value, _, err := field(" ")
+	if err != nil {
+		return 0, "", nil, err
+	}
+	txnr, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("invalid relp txnr %q", value)
+	}
+
+	command, _, err := field(" ")
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	value, delimiter, err := field(" \n")
+	if err != nil {
+		return 0, "", nil, err
+	}
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 0 || length > maxRELPFrame {
+		return 0, "", nil, fmt.Errorf("invalid relp data length %q", value)
+	}
+	if delimiter == '\n' {
+		if length != 0 {
+			return 0, "", nil, fmt.Errorf("invalid relp frame: missing data")
+		}
+		return txnr, command, nil, nil
+	}
+
+	data := make([]byte, length+1)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if data[length] != '\n' {
+		return 0, "", nil, fmt.Errorf("invalid relp frame: missing trailer")
+	}
+	return txnr, command, data[:length], nil
+}
@@ -0,0 +1,165 @@
+package client_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// relpServer is a minimal RELP server. It acknowledges syslog commands
+// with status, and hangs up after dropAfter of them when it is positive.
+type relpServer struct {
+	net.Listener
+	status    string
+	dropAfter int
+
+	mu       sync.Mutex
+	received []string
+	commands []string
+}
+
+func (s *relpServer) serve() {
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *relpServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	handled := 0
+	for {
+		txnr, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		txnr = strings.TrimSpace(txnr)
+		command, _ := reader.ReadString(' ')
+		command = strings.TrimSpace(command)
+
+		var length []byte
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			if b == ' ' || b == '\n' {
+				break
+			}
+			length = append(length, b)
+		}
+		var data string
+		if l, _ := strconv.Atoi(string(length)); l > 0 {
+			buffer := make([]byte, l+1)
+			io.ReadFull(reader, buffer)
+			data = string(buffer[:l])
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		switch command {
+		case "open":
+			fmt.Fprintf(conn, "%s rsp %d 200 OK\n%s\n", txnr, len("200 OK\n")+len(data), data)
+		case "syslog":
+			if s.dropAfter > 0 && handled == s.dropAfter {
+				return
+			}
+			handled++
+			status := s.status
+			if status == "200" {
+				s.mu.Lock()
+				s.received = append(s.received, data)
+				s.mu.Unlock()
+			}
+			fmt.Fprintf(conn, "%s rsp %d %s\n", txnr, len(status), status)
+		case "close":
+			fmt.Fprintf(conn, "%s rsp 0\n", txnr)
+			return
+		}
+	}
+}
+
+func (s *relpServer) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.received...)
+}
+
+func (s *relpServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.commands...)
+}
+
+func startRELP(status string, dropAfter int) *relpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	server := &relpServer{Listener: listener, status: status, dropAfter: dropAfter}
+	go server.serve()
+	return server
+}
+
+var _ = Describe("RELP", func() {
+	It("returns once the server acknowledges the message", func() {
+		server := startRELP("200", 0)
+
+		c, err := client.Dial("relp://"+server.Addr().String(), client.Options{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c.Send(message("hello"))).To(Succeed())
+		Expect(c.Send(message("multi\nline"))).To(Succeed())
+		Expect(server.Received()).To(Equal([]string{
+			message("hello").String(),
+			message("multi\nline").String(),
+		}))
+
+		Expect(c.Close()).To(Succeed())
+		Eventually(server.Commands).Should(Equal([]string{"open", "syslog", "syslog", "close"}))
+	})
+
+	It("fails when the server rejects the message", func() {
+		server := startRELP("500 busy", 0)
+
+		c, err := client.Dial("relp://"+server.Addr().String(), client.Options{MaxAttempts: 2, MinBackoff: 1})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		err = c.Send(message("hello"))
+		Expect(err).To(MatchError(ContainSubstring("500 busy")))
+		Expect(server.Received()).To(BeEmpty())
+	})
+
+	It("resends over a new session when the server hangs up before acknowledging", func() {
+		server := startRELP("200", 1)
+
+		c, err := client.Dial("relp://"+server.Addr().String(), client.Options{MinBackoff: 1})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+
+		Expect(c.Send(message("first"))).To(Succeed())
+		Expect(c.Send(message("second"))).To(Succeed())
+		Expect(server.Received()).To(Equal([]string{
+			message("first").String(),
+			message("second").String(),
+		}))
+	})
+})
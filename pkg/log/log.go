@@ -10,6 +10,121 @@ import (
 
 var paramValueReplacer = strings.NewReplacer(`"`, `\"`, `]`, `\]`, `\`, `\\`)
 
+// timestampLayout is RFC 3339 limited to the six fractional digits that
+// RFC 5424 allows.
+const timestampLayout = "2006-01-02T15:04:05.999999Z07:00"
+
+// The longest header fields and SD-NAMEs RFC 5424 allows, in bytes.
+const (
+	MaxHostnameLength = 255
+	MaxAppnameLength  = 48
+	MaxProcIDLength   = 128
+	MaxMsgIDLength    = 32
+	MaxSDNameLength   = 32
+)
+
+// ValidateHostname reports whether the hostname can be sent as is, being
+// printable US-ASCII without spaces, up to MaxHostnameLength.
+func ValidateHostname(hostname string) error {
+	return validateName("hostname", hostname, MaxHostnameLength, "")
+}
+
+// ValidateAppname is ValidateHostname for the APP-NAME.
+func ValidateAppname(appname string) error {
+	return validateName("appname", appname, MaxAppnameLength, "")
+}
+
+// ValidateProcID is ValidateHostname for the PROCID.
+func ValidateProcID(procID string) error {
+	return validateName("procid", procID, MaxProcIDLength, "")
+}
+
+// ValidateMsgID is ValidateHostname for the MSGID.
+func ValidateMsgID(msgID string) error {
+	return validateName("msgid", msgID, MaxMsgIDLength, "")
+}
+
+// ValidateSDName reports whether the name can be an SD-ID or PARAM-NAME,
+// being non-empty printable US-ASCII without spaces, '=', ']' or '"', up to
+// MaxSDNameLength.
+func ValidateSDName(name string) error {
+	if name == "" {
+		return fmt.Errorf("structured data name is empty")
+	}
+	return validateName("structured data name", name, MaxSDNameLength, sdNameExcluded)
+}
+
+// sdNameExcluded are the printable characters an SD-NAME can't have.
+const sdNameExcluded = `= ]"`
+
+func validateName(field, value string, max int, excluded string) error {
+	if len(value) > max {
+		return fmt.Errorf("%s %q is longer than %d bytes", field, value, max)
+	}
+	for i := 0; i < len(value); i++ {
+		if !printable(value[i]) || strings.IndexByte(excluded, value[i]) >= 0 {
+			return fmt.Errorf("%s %q has %q, which isn't allowed", field, value, value[i])
+		}
+	}
+	return nil
+}
+
+// SanitizeHostname makes the hostname valid for ValidateHostname, replacing
+// what isn't allowed with '_' and truncating it. It is what SetHostname
+// keeps.
+func SanitizeHostname(hostname string) string {
+	return sanitizeName(hostname, MaxHostnameLength, "")
+}
+
+// SanitizeAppname is SanitizeHostname for the APP-NAME.
+func SanitizeAppname(appname string) string {
+	return sanitizeName(appname, MaxAppnameLength, "")
+}
+
+// SanitizeProcID is SanitizeHostname for the PROCID.
+func SanitizeProcID(procID string) string {
+	return sanitizeName(procID, MaxProcIDLength, "")
+}
+
+// SanitizeMsgID is SanitizeHostname for the MSGID.
+func SanitizeMsgID(msgID string) string {
+	return sanitizeName(msgID, MaxMsgIDLength, "")
+}
+
+// SanitizeSDName is SanitizeHostname for SD-IDs and PARAM-NAMEs, which
+// can't be empty, so an empty name becomes "_".
+func SanitizeSDName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return sanitizeName(name, MaxSDNameLength, sdNameExcluded)
+}
+
+// sanitizeName makes a value valid for validateName, replacing what isn't
+// allowed with '_' and truncating it.
+func sanitizeName(value string, max int, excluded string) string {
+	valid := true
+	for i := 0; i < len(value) && valid; i++ {
+		valid = printable(value[i]) && strings.IndexByte(excluded, value[i]) < 0
+	}
+	if valid && len(value) <= max {
+		return value
+	}
+
+	sanitized := []byte(value[:min(len(value), max)])
+	for i, b := range sanitized {
+		if !printable(b) || strings.IndexByte(excluded, b) >= 0 {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
+
+// printable is PRINTUSASCII, which excludes the space.
+func printable(b byte) bool {
+	return b >= 33 && b <= 126
+}
+
 type Property struct {
 	Key   string
 	Value string
@@ -61,6 +176,10 @@ func (s *structureData) String() string {
 	return buffer.String()
 }
 
+// Log is an RFC 5424 message. The setters keep it valid, replacing
+// characters a field can't have with '_' and truncating values that are
+// too long; the Validate functions report whether a value would be
+// changed.
 type Log struct {
 	version   int
 	priority  int
@@ -73,6 +192,25 @@ type Log struct {
 	message   string
 }
 
+// New returns an empty version 1 log, to be filled in with the setters.
+func New() *Log {
+	return &Log{version: 1}
+}
+
+// Clone returns a copy of the log that can be changed without changing
+// the original.
+func (m *Log) Clone() *Log {
+	clone := *m
+	clone.data = make(structureData, len(m.data))
+	for i, element := range m.data {
+		clone.data[i] = structureElement{
+			id:         element.id,
+			properties: append([]Property{}, element.properties...),
+		}
+	}
+	return &clone
+}
+
 func (m *Log) Version() int {
 	return m.version
 }
@@ -89,34 +227,159 @@ func (m *Log) Priority() int {
 	return m.priority
 }
 
+func (m *Log) SetPriority(priority int) {
+	m.priority = priority
+}
+
+// SetFacilitySeverity sets the priority from its parts.
+func (m *Log) SetFacilitySeverity(facility, severity int) {
+	m.priority = facility<<3 | severity&7
+}
+
 func (m *Log) Timestamp() time.Time {
 	return m.timestamp
 }
 
+func (m *Log) SetTimestamp(timestamp time.Time) {
+	m.timestamp = timestamp
+}
+
 func (m *Log) Hostname() string {
 	return m.hostname
 }
 
+func (m *Log) SetHostname(hostname string) {
+	m.hostname = SanitizeHostname(hostname)
+}
+
 func (m *Log) Appname() string {
 	return m.appname
 }
 
+func (m *Log) SetAppname(appname string) {
+	m.appname = SanitizeAppname(appname)
+}
+
 func (m *Log) ProcID() string {
 	return m.procID
 }
 
+func (m *Log) SetProcID(procID string) {
+	m.procID = SanitizeProcID(procID)
+}
+
 func (m *Log) MsgID() string {
 	return m.msgID
 }
 
+func (m *Log) SetMsgID(msgID string) {
+	m.msgID = SanitizeMsgID(msgID)
+}
+
 func (m *Log) StructureData() structureData {
 	return m.data
 }
 
+// AddStructureElement appends an SD-ELEMENT. It doesn't merge with an
+// existing element of the same id.
+func (m *Log) AddStructureElement(id string, properties ...Property) {
+	sanitized := make([]Property, len(properties))
+	for i, property := range properties {
+		sanitized[i] = Property{Key: SanitizeSDName(property.Key), Value: property.Value}
+	}
+	m.data = append(m.data, structureElement{
+		id:         SanitizeSDName(id),
+		properties: sanitized,
+	})
+}
+
+// DeleteStructureElement removes every SD-ELEMENT with the id.
+func (m *Log) DeleteStructureElement(id string) {
+	data := m.data[:0]
+	for _, element := range m.data {
+		if element.id != id {
+			data = append(data, element)
+		}
+	}
+	m.data = data
+}
+
+// Param returns the value of the first SD-PARAM with the name in an
+// SD-ELEMENT with the id.
+func (m *Log) Param(id, name string) (string, bool) {
+	for _, element := range m.data {
+		if element.id != id {
+			continue
+		}
+		for _, property := range element.properties {
+			if property.Key == name {
+				return property.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetParam replaces the value of an SD-PARAM, adding the param, and the
+// SD-ELEMENT, when they don't exist.
+func (m *Log) SetParam(id, name, value string) {
+	id, name = SanitizeSDName(id), SanitizeSDName(name)
+	for i, element := range m.data {
+		if element.id != id {
+			continue
+		}
+		for j, property := range element.properties {
+			if property.Key == name {
+				m.data[i].properties[j].Value = value
+				return
+			}
+		}
+	}
+
+	for i, element := range m.data {
+		if element.id == id {
+			m.data[i].properties = append(element.properties, Property{Key: name, Value: value})
+			return
+		}
+	}
+	m.AddStructureElement(id, Property{Key: name, Value: value})
+}
+
+// DeleteParam removes an SD-PARAM from every SD-ELEMENT with the id. The
+// elements stay, even when left without params.
+func (m *Log) DeleteParam(id, name string) {
+	for i, element := range m.data {
+		if element.id != id {
+			continue
+		}
+		properties := make([]Property, 0, len(element.properties))
+		for _, property := range element.properties {
+			if property.Key != name {
+				properties = append(properties, property)
+			}
+		}
+		m.data[i].properties = properties
+	}
+}
+
+// UpdateParams replaces the value of every SD-PARAM with what fn returns
+// for it.
+func (m *Log) UpdateParams(fn func(id, name, value string) string) {
+	for i, element := range m.data {
+		for j, property := range element.properties {
+			m.data[i].properties[j].Value = fn(element.id, property.Key, property.Value)
+		}
+	}
+}
+
 func (m *Log) Message() string {
 	return m.message
 }
 
+func (m *Log) SetMessage(message string) {
+	m.message = message
+}
+
 func (m *Log) String() string {
 	var buffer strings.Builder
 	buffer.WriteString("<")
@@ -127,7 +390,7 @@ func (m *Log) String() string {
 	if m.timestamp.IsZero() {
 		buffer.WriteString("-")
 	} else {
-		buffer.WriteString(m.timestamp.Format(time.RFC3339Nano))
+		buffer.WriteString(m.timestamp.Format(timestampLayout))
 	}
 	buffer.WriteString(" ")
 	if m.hostname == "" {
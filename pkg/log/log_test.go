@@ -0,0 +1,154 @@
+package syslog_test
+
+import (
+	"strings"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	It("can be built with the setters", func() {
+		log := syslog.New()
+		log.SetFacilitySeverity(4, 2)
+		log.SetTimestamp(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC))
+		log.SetHostname("mymachine.example.com")
+		log.SetAppname("su")
+		log.SetProcID("12345")
+		log.SetMsgID("98765")
+		log.SetMessage("'su root' failed for lonvick on /dev/pts/8")
+
+		Expect(log.Priority()).To(Equal(34))
+		Expect(log.String()).To(Equal("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su 12345 98765 - 'su root' failed for lonvick on /dev/pts/8"))
+
+		parsed, _, err := syslog.Parse([]byte(log.String()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(log))
+	})
+
+	It("renders timestamps with at most microsecond precision", func() {
+		log := syslog.New()
+		log.SetTimestamp(time.Date(2003, 10, 11, 22, 14, 15, 123456789, time.UTC))
+		Expect(log.String()).To(Equal("<0>1 2003-10-11T22:14:15.123456Z - - - - -"))
+
+		_, _, err := syslog.Parse([]byte(log.String()))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("renders an empty log as a valid message", func() {
+		log := syslog.New()
+		Expect(log.String()).To(Equal("<0>1 - - - - - -"))
+
+		_, _, err := syslog.Parse([]byte(log.String()))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("keeps what the setters are given valid", func() {
+		log := syslog.New()
+		log.SetHostname("web 1")
+		log.SetAppname(strings.Repeat("a", 60))
+		log.SetProcID("pid\x00")
+		log.SetMsgID(strings.Repeat("é", 20))
+		log.AddStructureElement("my site", syslog.Property{Key: `a="b"`, Value: "c"})
+		log.SetParam("meta]", "", "d")
+
+		Expect(log.Hostname()).To(Equal("web_1"))
+		Expect(log.Appname()).To(Equal(strings.Repeat("a", 48)))
+		Expect(log.ProcID()).To(Equal("pid_"))
+		Expect(log.MsgID()).To(Equal(strings.Repeat("_", 32)))
+		Expect(log.String()).To(Equal(`<0>1 - web_1 ` + strings.Repeat("a", 48) + ` pid_ ` + strings.Repeat("_", 32) + ` [my_site a__b_="c"][meta_ _="d"]`))
+
+		parsed, _, err := syslog.Parse([]byte(log.String()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.String()).To(Equal(log.String()))
+	})
+
+	DescribeTable("validates fields", func(validate func(string) error, value string, message string) {
+		err := validate(value)
+		if message == "" {
+			Expect(err).ToNot(HaveOccurred())
+			return
+		}
+		Expect(err).To(MatchError(ContainSubstring(message)))
+	},
+		Entry("a hostname", syslog.ValidateHostname, "web-1.example.com", ""),
+		Entry("an empty hostname", syslog.ValidateHostname, "", ""),
+		Entry("a hostname with a space", syslog.ValidateHostname, "web 1", `hostname "web 1" has ' '`),
+		Entry("a long hostname", syslog.ValidateHostname, strings.Repeat("a", 256), "longer than 255 bytes"),
+		Entry("a long appname", syslog.ValidateAppname, strings.Repeat("a", 49), "longer than 48 bytes"),
+		Entry("a long procid", syslog.ValidateProcID, strings.Repeat("a", 129), "longer than 128 bytes"),
+		Entry("a long msgid", syslog.ValidateMsgID, strings.Repeat("a", 33), "longer than 32 bytes"),
+		Entry("a non-ASCII msgid", syslog.ValidateMsgID, "é", "which isn't allowed"),
+		Entry("an SD-ID", syslog.ValidateSDName, "meta@32473", ""),
+		Entry("an empty SD-ID", syslog.ValidateSDName, "", "empty"),
+		Entry("an SD-ID with a space", syslog.ValidateSDName, "my site", `has ' '`),
+		Entry("an SD-ID with an equals", syslog.ValidateSDName, "a=b", `has '='`),
+		Entry("an SD-ID with a bracket", syslog.ValidateSDName, "a]", `has ']'`),
+		Entry("an SD-ID with a quote", syslog.ValidateSDName, `a"`, `has '"'`),
+		Entry("a long SD-ID", syslog.ValidateSDName, strings.Repeat("a", 33), "longer than 32 bytes"),
+	)
+
+	It("can add structure data", func() {
+		log := syslog.New()
+		log.AddStructureElement("origin", syslog.Property{Key: "ip", Value: "10.0.0.1"})
+		log.AddStructureElement("meta@32473", syslog.Property{Key: "quote", Value: `say "hi"`})
+
+		Expect(log.String()).To(Equal(`<0>1 - - - - - [origin ip="10.0.0.1"][meta@32473 quote="say \"hi\""]`))
+
+		parsed, _, err := syslog.Parse([]byte(log.String()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.StructureData()[1].Properties()).To(Equal([]syslog.Property{{Key: "quote", Value: `say "hi"`}}))
+	})
+
+	It("can change structure data", func() {
+		log := syslog.New()
+		log.AddStructureElement("origin", syslog.Property{Key: "ip", Value: "10.0.0.1"})
+		log.AddStructureElement("meta", syslog.Property{Key: "a", Value: "1"}, syslog.Property{Key: "b", Value: "2"})
+
+		log.SetParam("origin", "ip", "10.0.0.2")
+		log.SetParam("meta", "c", "3")
+		log.SetParam("enrich", "site", "dc1")
+		Expect(log.String()).To(Equal(`<0>1 - - - - - [origin ip="10.0.0.2"][meta a="1" b="2" c="3"][enrich site="dc1"]`))
+
+		value, ok := log.Param("meta", "b")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("2"))
+		_, ok = log.Param("meta", "missing")
+		Expect(ok).To(BeFalse())
+
+		log.UpdateParams(func(id, name, value string) string {
+			if id == "meta" {
+				return name + value
+			}
+			return value
+		})
+		Expect(log.String()).To(Equal(`<0>1 - - - - - [origin ip="10.0.0.2"][meta a="a1" b="b2" c="c3"][enrich site="dc1"]`))
+
+		log.DeleteParam("meta", "b")
+		log.DeleteStructureElement("origin")
+		Expect(log.String()).To(Equal(`<0>1 - - - - - [meta a="a1" c="c3"][enrich site="dc1"]`))
+	})
+
+	It("can be cloned without sharing structure data", func() {
+		log := syslog.New()
+		log.SetMessage("original")
+		log.AddStructureElement("origin", syslog.Property{Key: "ip", Value: "10.0.0.1"})
+
+		clone := log.Clone()
+		clone.SetMessage("changed")
+		clone.SetParam("origin", "ip", "10.0.0.2")
+		clone.AddStructureElement("meta", syslog.Property{Key: "a", Value: "1"})
+
+		Expect(log.String()).To(Equal(`<0>1 - - - - - [origin ip="10.0.0.1"] original`))
+		Expect(clone.String()).To(Equal(`<0>1 - - - - - [origin ip="10.0.0.2"][meta a="1"] changed`))
+	})
+
+	It("can set the priority directly", func() {
+		log := syslog.New()
+		log.SetPriority(191)
+		Expect(log.Facility()).To(Equal(23))
+		Expect(log.Severity()).To(Equal(7))
+	})
+})
@@ -0,0 +1,376 @@
+// Package metrics keeps counters, gauges and histograms and exposes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are latency buckets in seconds, matching the Prometheus
+// client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// Registry holds metric families by name. Asking for a family that already
+// exists returns it, so several receivers can share one registry.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu       sync.Mutex
+	children map[string]*child
+}
+
+type child struct {
+	values    []string
+	counter   *Counter
+	gauge     *Gauge
+	histogram *Histogram
+}
+
+func (r *Registry) family(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metric %s already registered as a different type", name))
+		}
+		return f
+	}
+
+	f := &family{
+		name:     name,
+		help:     help,
+		kind:     k,
+		labels:   labels,
+		buckets:  buckets,
+		children: map[string]*child{},
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *child {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c, ok := f.children[key]; ok {
+		return c
+	}
+
+	c := &child{values: append([]string(nil), values...)}
+	switch f.kind {
+	case counterKind:
+		c.counter = &Counter{}
+	case gaugeKind:
+		c.gauge = &Gauge{}
+	case histogramKind:
+		c.histogram = newHistogram(f.buckets)
+	}
+	f.children[key] = c
+	return c
+}
+
+type CounterVec struct{ family *family }
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.family(name, help, counterKind, nil, labels)}
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.family.with(values).counter
+}
+
+type GaugeVec struct{ family *family }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.family(name, help, gaugeKind, nil, labels)}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.family.with(values).gauge
+}
+
+type HistogramVec struct{ family *family }
+
+// Histogram registers a histogram with the given upper bounds. Nil buckets
+// use DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{family: r.family(name, help, histogramKind, buckets, labels)}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.family.with(values).histogram
+}
+
+// float is a float64 that can be updated atomically.
+type float struct {
+	bits atomic.Uint64
+}
+
+func (f *float) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if f.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func (f *float) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+type Counter struct {
+	value float
+}
+
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add increases the counter. Negative values are ignored, as counters only
+// go up.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.value.add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.value.load()
+}
+
+type Gauge struct {
+	value float
+	fn    atomic.Pointer[func() float64]
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.value.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// SetFunc makes the gauge report fn's result when read, for values such as
+// queue depth that are cheaper to sample than to track.
+func (g *Gauge) SetFunc(fn func() float64) {
+	g.fn.Store(&fn)
+}
+
+func (g *Gauge) Value() float64 {
+	if fn := g.fn.Load(); fn != nil {
+		return (*fn)()
+	}
+	return g.value.load()
+}
+
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     float
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(value)
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (h *Histogram) Sum() float64 {
+	return h.sum.load()
+}
+
+// ServeHTTP writes every metric in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by
+// name and then by label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	counter := &countingWriter{writer: w}
+	buffer := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buffer)
+	}
+	err := buffer.Flush()
+	return counter.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	children := make([]*child, 0, len(f.children))
+	for _, c := range f.children {
+		children = append(children, c)
+	}
+	f.mu.Unlock()
+	if len(children) == 0 {
+		return
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, c := range children {
+		labels := f.labelPairs(c.values)
+		switch f.kind {
+		case counterKind:
+			writeSample(w, f.name, labels, c.counter.Value())
+		case gaugeKind:
+			writeSample(w, f.name, labels, c.gauge.Value())
+		case histogramKind:
+			h := c.histogram
+			cumulative := uint64(0)
+			for i, bound := range h.buckets {
+				cumulative += h.counts[i].Load()
+				writeSample(w, f.name+"_bucket", append(labels, [2]string{"le", formatFloat(bound)}), float64(cumulative))
+			}
+			count := h.count.Load()
+			writeSample(w, f.name+"_bucket", append(labels, [2]string{"le", "+Inf"}), float64(count))
+			writeSample(w, f.name+"_sum", labels, h.sum.load())
+			writeSample(w, f.name+"_count", labels, float64(count))
+		}
+	}
+}
+
+func (f *family) labelPairs(values []string) [][2]string {
+	pairs := make([][2]string, len(values), len(values)+1)
+	for i, value := range values {
+		pairs[i] = [2]string{f.labels[i], value}
+	}
+	return pairs
+}
+
+func writeSample(w *bufio.Writer, name string, labels [][2]string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteString("{")
+		for i, pair := range labels {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(pair[0])
+			w.WriteString(`="`)
+			w.WriteString(labelValueReplacer.Replace(pair[1]))
+			w.WriteString(`"`)
+		}
+		w.WriteString("}")
+	}
+	w.WriteString(" ")
+	w.WriteString(formatFloat(value))
+	w.WriteString("\n")
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
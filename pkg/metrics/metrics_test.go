@@ -0,0 +1,97 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jtarchie/syslog/pkg/metrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func scrape(registry *metrics.Registry) string {
+	builder := &strings.Builder{}
+	_, err := registry.WriteTo(builder)
+	Expect(err).ToNot(HaveOccurred())
+	return builder.String()
+}
+
+var _ = Describe("Registry", func() {
+	It("writes counters with labels", func() {
+		registry := metrics.NewRegistry()
+		dropped := registry.Counter("dropped_total", "Messages dropped.", "reason")
+		dropped.With("overflow").Inc()
+		dropped.With("overflow").Add(2)
+		dropped.With(`quo"te`).Inc()
+		dropped.With("ignored").Add(-1)
+
+		Expect(scrape(registry)).To(Equal(`# HELP dropped_total Messages dropped.
+# TYPE dropped_total counter
+dropped_total{reason="ignored"} 0
+dropped_total{reason="overflow"} 3
+dropped_total{reason="quo\"te"} 1
+`))
+	})
+
+	It("writes gauges, including sampled ones", func() {
+		registry := metrics.NewRegistry()
+		registry.Gauge("in_flight", "In flight.").With().Set(4)
+		depth := 0
+		registry.Gauge("queue_depth", "Queue depth.", "listener").With("a").SetFunc(func() float64 {
+			depth++
+			return float64(depth)
+		})
+
+		Expect(scrape(registry)).To(Equal(`# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 4
+# HELP queue_depth Queue depth.
+# TYPE queue_depth gauge
+queue_depth{listener="a"} 1
+`))
+		Expect(scrape(registry)).To(ContainSubstring(`queue_depth{listener="a"} 2`))
+	})
+
+	It("writes cumulative histogram buckets", func() {
+		registry := metrics.NewRegistry()
+		latency := registry.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "op").With("write")
+		latency.Observe(0.05)
+		latency.Observe(0.1)
+		latency.Observe(0.5)
+		latency.Observe(3)
+
+		Expect(scrape(registry)).To(Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="write",le="0.1"} 2
+latency_seconds_bucket{op="write",le="1"} 3
+latency_seconds_bucket{op="write",le="+Inf"} 4
+latency_seconds_sum{op="write"} 3.65
+latency_seconds_count{op="write"} 4
+`))
+	})
+
+	It("returns the same metric when registered twice", func() {
+		registry := metrics.NewRegistry()
+		registry.Counter("total", "Total.").With().Inc()
+		registry.Counter("total", "Total.").With().Inc()
+
+		Expect(registry.Counter("total", "Total.").With().Value()).To(Equal(2.0))
+		Expect(func() {
+			registry.Gauge("total", "Total.")
+		}).To(Panic())
+	})
+
+	It("serves the metrics over HTTP", func() {
+		registry := metrics.NewRegistry()
+		registry.Counter("total", "Total.").With().Inc()
+
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		Expect(recorder.Code).To(Equal(200))
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		body, _ := io.ReadAll(recorder.Body)
+		Expect(string(body)).To(ContainSubstring("total 1\n"))
+	})
+})
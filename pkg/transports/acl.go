@@ -0,0 +1,70 @@
+package transports
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseCIDRs parses networks such as "10.0.0.0/8". Bare addresses are
+// treated as a network of one.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %s", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+type acl struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func (a acl) permits(addr net.Addr) bool {
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(peerIP(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package transports
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// batcher groups messages from the workers and hands them to the writer
+// once BatchSize is reached or BatchInterval has passed. Full batches are
+// written by the worker that filled them, so a slow writer pushes back on
+// the workers and the queue's overflow policy applies.
+type batcher struct {
+	writer  BatchWriter
+	options Options
+	metrics *receiverMetrics
+
+	mu        sync.Mutex
+	logs      []*syslog.Log
+	envelopes []Envelope
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBatcher(w BatchWriter, options Options, metrics *receiverMetrics) *batcher {
+	b := &batcher{
+		writer:  w,
+		options: options,
+		metrics: metrics,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) add(l *syslog.Log, envelope Envelope) {
+	b.mu.Lock()
+	b.logs = append(b.logs, l)
+	b.envelopes = append(b.envelopes, envelope)
+	if len(b.logs) < b.options.BatchSize {
+		b.mu.Unlock()
+		return
+	}
+	logs, envelopes := b.take()
+	b.mu.Unlock()
+
+	b.write(logs, envelopes)
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.options.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			logs, envelopes := b.take()
+			b.mu.Unlock()
+
+			b.write(logs, envelopes)
+		}
+	}
+}
+
+// take must be called with the lock held.
+func (b *batcher) take() ([]*syslog.Log, []Envelope) {
+	logs, envelopes := b.logs, b.envelopes
+	b.logs = make([]*syslog.Log, 0, b.options.BatchSize)
+	b.envelopes = make([]Envelope, 0, b.options.BatchSize)
+	return logs, envelopes
+}
+
+func (b *batcher) write(logs []*syslog.Log, envelopes []Envelope) {
+	if len(logs) == 0 {
+		return
+	}
+
+	ctx, cancel := b.context()
+	defer cancel()
+
+	start := time.Now()
+	err := b.writer.WriteBatch(WithEnvelopes(ctx, envelopes), logs)
+	b.metrics.writeDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		b.metrics.writeErrors.Inc()
+		log.Printf("could not write %d messages: %s", len(logs), err)
+	}
+}
+
+func (b *batcher) context() (context.Context, context.CancelFunc) {
+	if b.options.WriteTimeout > 0 {
+		return context.WithTimeout(context.Background(), b.options.WriteTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// close writes anything pending and flushes the writer. It must only be
+// called once the workers have stopped adding.
+func (b *batcher) close() error {
+	close(b.stop)
+	<-b.done
+
+	b.mu.Lock()
+	logs, envelopes := b.take()
+	b.mu.Unlock()
+	b.write(logs, envelopes)
+
+	ctx, cancel := b.context()
+	defer cancel()
+	return b.writer.Flush(ctx)
+}
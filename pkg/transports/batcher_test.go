@@ -0,0 +1,180 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type BatchSpyWriter struct {
+	batches   [][]*syslog.Log
+	envelopes [][]transports.Envelope
+	flushes   int
+	closed    bool
+	mu        sync.Mutex
+}
+
+func (b *BatchSpyWriter) Write(log *syslog.Log) error {
+	return errors.New("Write should not be called on a BatchWriter")
+}
+
+func (b *BatchSpyWriter) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batches = append(b.batches, logs)
+	b.envelopes = append(b.envelopes, transports.EnvelopesFromContext(ctx))
+	return nil
+}
+
+func (b *BatchSpyWriter) Flush(context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flushes++
+	return nil
+}
+
+func (b *BatchSpyWriter) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+func (b *BatchSpyWriter) BatchSizes() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sizes := []int{}
+	for _, batch := range b.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func (b *BatchSpyWriter) Flushes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flushes
+}
+
+var _ = Describe("Batching", func() {
+	sendMessages := func(server *transports.UDPServer, count int) {
+		conn, err := net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		for i := 0; i < count; i++ {
+			_, err = conn.Write([]byte("<34>1 - host app - - - hello"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	It("writes a batch once it is full", func() {
+		writer := &BatchSpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			BatchSize:     5,
+			BatchInterval: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		sendMessages(server, 10)
+
+		Eventually(writer.BatchSizes).Should(Equal([]int{5, 5}))
+		for _, envelopes := range writer.envelopes {
+			Expect(envelopes).To(HaveLen(5))
+			Expect(envelopes[0].Transport).To(Equal("udp"))
+		}
+	})
+
+	It("writes a partial batch once the interval passes", func() {
+		writer := &BatchSpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			BatchSize:     100,
+			BatchInterval: 50 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		sendMessages(server, 3)
+
+		Eventually(writer.BatchSizes).Should(Equal([]int{3}))
+	})
+
+	It("writes what is pending and flushes when closed", func() {
+		writer := &BatchSpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			BatchSize:     100,
+			BatchInterval: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		done := make(chan error)
+		go func() { done <- server.Start() }()
+
+		sendMessages(server, 3)
+		Eventually(func() uint64 {
+			return server.Stats().Received
+		}).Should(BeEquivalentTo(3))
+		Expect(writer.BatchSizes()).To(BeEmpty())
+
+		Expect(server.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(writer.BatchSizes()).To(Equal([]int{3}))
+		Expect(writer.Flushes()).To(Equal(1))
+		Expect(writer.closed).To(BeFalse())
+	})
+
+	Context("with a single message writer", func() {
+		It("adapts it to write each message of the batch", func() {
+			spy := &EnvelopeSpyWriter{}
+			writer := transports.NewBatchWriter(spy)
+
+			log, _, err := syslog.Parse([]byte("<34>1 - host app - - - hello"))
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx := transports.WithEnvelopes(context.Background(), []transports.Envelope{
+				{Transport: "udp"},
+				{Transport: "http"},
+			})
+			Expect(writer.WriteBatch(ctx, []*syslog.Log{log, log})).To(Succeed())
+
+			Expect(spy.Logs()).To(HaveLen(2))
+			Expect(spy.Envelopes()).To(HaveLen(2))
+			Expect(spy.Envelopes()[1].Transport).To(Equal("http"))
+			Expect(writer.Flush(ctx)).To(Succeed())
+			Expect(writer.Close()).To(Succeed())
+		})
+
+		It("stops when the context is done", func() {
+			spy := &SpyWriter{}
+			writer := transports.NewBatchWriter(spy)
+
+			log, _, err := syslog.Parse([]byte("<34>1 - host app - - - hello"))
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			Expect(writer.WriteBatch(ctx, []*syslog.Log{log})).To(MatchError(context.Canceled))
+			Expect(spy.Logs()).To(BeEmpty())
+		})
+
+		It("returns writers that already batch as is", func() {
+			writer := &BatchSpyWriter{}
+			Expect(transports.NewBatchWriter(writer)).To(BeIdenticalTo(writer))
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package transports_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+// CountingWriter only counts, so the benchmark measures the receive path
+// rather than the writer.
+type CountingWriter struct {
+	count atomic.Int64
+}
+
+func (c *CountingWriter) Write(*syslog.Log) error {
+	c.count.Add(1)
+	return nil
+}
+
+// BenchmarkUDPServer floods each server configuration with cmd/flood.
+// Use a fixed count to compare delivery, e.g. -benchtime=200000x.
+func BenchmarkUDPServer(b *testing.B) {
+	if testing.Short() {
+		b.Skip("builds and runs cmd/flood")
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	flood := filepath.Join(b.TempDir(), "flood")
+	output, err := exec.Command("go", "build", "-o", flood, "../../cmd/flood").CombinedOutput()
+	if err != nil {
+		b.Fatalf("could not build flood: %s\n%s", err, output)
+	}
+
+	cases := []struct {
+		label   string
+		options transports.Options
+	}{
+		{"ReadFrom", transports.Options{ReadBatchSize: 1}},
+		{"ReadBatch", transports.Options{ReadBatchSize: 32}},
+		{"ReadBatch+ReadBuffer", transports.Options{ReadBatchSize: 32, ReadBuffer: 4 * 1024 * 1024}},
+		{"ReadBatch+ReusePort", transports.Options{ReadBatchSize: 32, ReadBuffer: 4 * 1024 * 1024, Listeners: 4}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.label, func(b *testing.B) {
+			writer := &CountingWriter{}
+			server, err := transports.NewUDPServer(0, writer, c.options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			go server.Start()
+			defer server.Close()
+
+			const workers = 4
+			perWorker := b.N/workers + 1
+
+			b.ResetTimer()
+			output, err := exec.Command(
+				flood,
+				"-uri", server.Addr().String(),
+				"-num-workers", fmt.Sprint(workers),
+				"-num-messages", fmt.Sprint(perWorker),
+			).CombinedOutput()
+			if err != nil {
+				b.Fatalf("could not run flood: %s\n%s", err, output)
+			}
+
+			// wait for the workers to settle before counting what arrived
+			last := int64(-1)
+			for last != writer.count.Load() {
+				last = writer.count.Load()
+				time.Sleep(50 * time.Millisecond)
+			}
+			b.StopTimer()
+
+			sent := float64(workers * perWorker)
+			b.ReportMetric(100*float64(last)/sent, "%delivered")
+		})
+	}
+}
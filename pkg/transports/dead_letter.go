@@ -0,0 +1,171 @@
+package transports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a message that could not be parsed, kept so it can be
+// diagnosed or replayed.
+type DeadLetter struct {
+	Payload    []byte    `json:"payload"`
+	Peer       string    `json:"peer,omitempty"`
+	Transport  string    `json:"transport"`
+	ReceivedAt time.Time `json:"received_at"`
+	Error      string    `json:"error"`
+}
+
+type DeadLetterSink interface {
+	WriteDeadLetter(DeadLetter) error
+}
+
+// DeadLetterWriter writes dead letters as JSON lines to w.
+type DeadLetterWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewDeadLetterWriter(w io.Writer) *DeadLetterWriter {
+	return &DeadLetterWriter{encoder: json.NewEncoder(w)}
+}
+
+func (d *DeadLetterWriter) WriteDeadLetter(letter DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.encoder.Encode(letter)
+}
+
+// DeadLetterFile writes dead letters as JSON lines to path. Once the file
+// grows past maxBytes it is rotated to path.1, path.2 and so on, keeping at
+// most maxFiles rotated files.
+type DeadLetterFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewDeadLetterFile(path string, maxBytes int64, maxFiles int) (*DeadLetterFile, error) {
+	d := &DeadLetterFile{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+	}
+
+	err := d.open()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DeadLetterFile) open() error {
+	file, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open dead letter file: %s", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open dead letter file: %s", err)
+	}
+
+	d.file = file
+	d.size = info.Size()
+	return nil
+}
+
+func (d *DeadLetterFile) WriteDeadLetter(letter DeadLetter) error {
+	contents, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	contents = append(contents, '\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.maxBytes > 0 && d.size > 0 && d.size+int64(len(contents)) > d.maxBytes {
+		err = d.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := d.file.Write(contents)
+	d.size += int64(n)
+	return err
+}
+
+func (d *DeadLetterFile) rotate() error {
+	err := d.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if d.maxFiles > 0 {
+		for i := d.maxFiles - 1; i > 0; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", d.path, i), fmt.Sprintf("%s.%d", d.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(d.path, d.path+".1")
+	} else {
+		err = os.Remove(d.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.open()
+}
+
+func (d *DeadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+// ReadDeadLetters calls fn with each dead letter read from r, stopping at
+// the first error. Lines are read whole, however long, as a letter can
+// hold an HTTP body of up to MaxBodySize, which is larger once encoded.
+func ReadDeadLetters(r io.Reader, fn func(DeadLetter) error) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var letter DeadLetter
+			unmarshalErr := json.Unmarshal(line, &letter)
+			if unmarshalErr != nil {
+				return fmt.Errorf("could not read dead letter: %s", unmarshalErr)
+			}
+
+			fnErr := fn(letter)
+			if fnErr != nil {
+				return fnErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
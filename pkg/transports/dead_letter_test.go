@@ -0,0 +1,128 @@
+package transports_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type DeadLetterSpy struct {
+	letters []transports.DeadLetter
+	mu      sync.Mutex
+}
+
+func (d *DeadLetterSpy) WriteDeadLetter(letter transports.DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.letters = append(d.letters, letter)
+	return nil
+}
+
+func (d *DeadLetterSpy) Letters() []transports.DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.letters
+}
+
+var _ = Describe("DeadLetters", func() {
+	It("captures messages that fail to parse", func() {
+		writer := &SpyWriter{}
+		letters := &DeadLetterSpy{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			DeadLetters: letters,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		conn, err := net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		before := time.Now()
+		_, err = conn.Write([]byte("not a syslog message"))
+		Expect(err).ToNot(HaveOccurred())
+		SendMessage(server)
+
+		Eventually(letters.Letters).Should(HaveLen(1))
+		Eventually(writer.Logs).Should(HaveLen(1))
+
+		letter := letters.Letters()[0]
+		Expect(string(letter.Payload)).To(Equal("not a syslog message"))
+		Expect(letter.Peer).To(Equal(conn.LocalAddr().String()))
+		Expect(letter.Transport).To(Equal("udp"))
+		Expect(letter.ReceivedAt).To(BeTemporally(">=", before))
+		Expect(letter.Error).To(Equal("could not parse message"))
+	})
+
+	It("reads back what was written", func() {
+		buffer := &bytes.Buffer{}
+		writer := transports.NewDeadLetterWriter(buffer)
+
+		written := []transports.DeadLetter{
+			{Payload: []byte("first"), Peer: "127.0.0.1:1234", Transport: "udp", ReceivedAt: time.Unix(10, 0).UTC(), Error: "bad"},
+			{Payload: []byte("second\nwith \x00 bytes"), Transport: "udp", ReceivedAt: time.Unix(20, 0).UTC(), Error: "worse"},
+		}
+		for _, letter := range written {
+			Expect(writer.WriteDeadLetter(letter)).To(Succeed())
+		}
+
+		read := []transports.DeadLetter{}
+		err := transports.ReadDeadLetters(buffer, func(letter transports.DeadLetter) error {
+			read = append(read, letter)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(written))
+	})
+
+	It("reads back letters as large as an HTTP body", func() {
+		buffer := &bytes.Buffer{}
+		writer := transports.NewDeadLetterWriter(buffer)
+
+		large := transports.DeadLetter{Payload: bytes.Repeat([]byte("a"), 5*1024*1024), Transport: "http", Error: "bad"}
+		Expect(writer.WriteDeadLetter(large)).To(Succeed())
+		Expect(writer.WriteDeadLetter(transports.DeadLetter{Payload: []byte("after"), Transport: "http", Error: "bad"})).To(Succeed())
+
+		read := [][]byte{}
+		err := transports.ReadDeadLetters(buffer, func(letter transports.DeadLetter) error {
+			read = append(read, letter.Payload)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal([][]byte{large.Payload, []byte("after")}))
+	})
+
+	It("rotates the file once it is too large", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "dead-letters")
+		file, err := transports.NewDeadLetterFile(path, 200, 2)
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+
+		for i := 0; i < 10; i++ {
+			Expect(file.WriteDeadLetter(transports.DeadLetter{
+				Payload: bytes.Repeat([]byte("a"), 50),
+				Error:   "could not parse message",
+			})).To(Succeed())
+		}
+
+		matches, err := filepath.Glob(path + "*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(matches).To(ConsistOf(path, path+".1", path+".2"))
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<=", 200))
+		}
+	})
+})
@@ -0,0 +1,177 @@
+package transports
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// Envelope describes how and when a message was received. It is passed
+// to writers that implement EnvelopeWriter.
+type Envelope struct {
+	Peer       net.Addr
+	Local      net.Addr
+	Transport  string
+	ReceivedAt time.Time
+	// TLS is only set for transports that terminate TLS.
+	TLS *TLSIdentity
+}
+
+type TLSIdentity struct {
+	ServerName string
+	CommonName string
+	DNSNames   []string
+	// Fingerprint is the hex encoded SHA-256 of the peer certificate.
+	Fingerprint string
+}
+
+func NewTLSIdentity(state *tls.ConnectionState) *TLSIdentity {
+	if state == nil {
+		return nil
+	}
+
+	identity := &TLSIdentity{ServerName: state.ServerName}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		identity.CommonName = cert.Subject.CommonName
+		identity.DNSNames = cert.DNSNames
+		sum := sha256.Sum256(cert.Raw)
+		identity.Fingerprint = hex.EncodeToString(sum[:])
+	}
+	return identity
+}
+
+// EnvelopeWriter is implemented by writers that want to know where a
+// message came from. They are called instead of Write.
+type EnvelopeWriter interface {
+	WriteEnvelope(*syslog.Log, Envelope) error
+}
+
+func write(w Writer, l *syslog.Log, envelope Envelope) error {
+	if ew, ok := w.(EnvelopeWriter); ok {
+		return ew.WriteEnvelope(l, envelope)
+	}
+	return w.Write(l)
+}
+
+// HostnameSource decides how an empty hostname is filled in.
+type HostnameSource int
+
+const (
+	// HostnameAsIs leaves the hostname as sent.
+	HostnameAsIs HostnameSource = iota
+	// HostnameFromPeerIP uses the peer's IP address.
+	HostnameFromPeerIP
+	// HostnameFromReverseDNS uses the peer's PTR record, falling back to
+	// its IP address. Lookups happen in the background, so messages are
+	// left as sent until the peer's lookup has finished.
+	HostnameFromReverseDNS
+)
+
+const (
+	reverseDNSTTL     = 5 * time.Minute
+	reverseDNSTimeout = 2 * time.Second
+	// maxResolvedHostnames bounds the memory used by spoofed or churning
+	// peers
+	maxResolvedHostnames = 10000
+)
+
+type hostnameResolver struct {
+	source   HostnameSource
+	resolver *net.Resolver
+	lookups  singleflight.Group
+
+	mu    sync.Mutex
+	cache *lru[string, resolvedHostname]
+}
+
+type resolvedHostname struct {
+	hostname string
+	expires  time.Time
+}
+
+func newHostnameResolver(source HostnameSource) *hostnameResolver {
+	return &hostnameResolver{
+		source:   source,
+		resolver: net.DefaultResolver,
+		cache:    newLRU[string, resolvedHostname](maxResolvedHostnames),
+	}
+}
+
+func (r *hostnameResolver) fill(l *syslog.Log, envelope Envelope) {
+	if r.source == HostnameAsIs || l.Hostname() != "" || envelope.Peer == nil {
+		return
+	}
+
+	ip := peerIP(envelope.Peer)
+	if ip == "" {
+		return
+	}
+
+	if r.source == HostnameFromReverseDNS {
+		if hostname := r.lookup(ip); hostname != "" {
+			l.SetHostname(hostname)
+		}
+		return
+	}
+	l.SetHostname(ip)
+}
+
+// lookup returns the cached hostname for the ip, empty when there is none
+// yet. Missing and expired entries are looked up without waiting, once
+// however many messages ask; an expired hostname is used until then.
+func (r *hostnameResolver) lookup(ip string) string {
+	r.mu.Lock()
+	resolved, ok := r.cache.get(ip)
+	r.mu.Unlock()
+
+	if !ok || time.Now().After(resolved.expires) {
+		r.lookups.DoChan(ip, func() (interface{}, error) {
+			r.resolve(ip)
+			return nil, nil
+		})
+	}
+	return resolved.hostname
+}
+
+func (r *hostnameResolver) resolve(ip string) {
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+
+	hostname := ip
+	names, err := r.resolver.LookupAddr(ctx, ip)
+	if err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache.add(ip, resolvedHostname{hostname: hostname, expires: time.Now().Add(reverseDNSTTL)})
+}
+
+func peerIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,180 @@
+package transports_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type EnvelopeSpyWriter struct {
+	SpyWriter
+	envelopes []transports.Envelope
+	mu        sync.Mutex
+	// gate, when set, blocks each write after it is recorded
+	gate chan struct{}
+}
+
+func (e *EnvelopeSpyWriter) WriteEnvelope(log *syslog.Log, envelope transports.Envelope) error {
+	e.mu.Lock()
+	e.envelopes = append(e.envelopes, envelope)
+	e.mu.Unlock()
+
+	e.SpyWriter.Write(log)
+	if e.gate != nil {
+		<-e.gate
+	}
+	return nil
+}
+
+func (e *EnvelopeSpyWriter) Envelopes() []transports.Envelope {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.envelopes
+}
+
+var _ = Describe("Envelope", func() {
+	sendFrom := func(server *transports.UDPServer, payload string) net.Addr {
+		addr := server.Addr().(*net.UDPAddr)
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: addr.Port})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(payload))
+		Expect(err).ToNot(HaveOccurred())
+		return conn.LocalAddr()
+	}
+
+	It("passes where the message came from to the writer", func() {
+		writer := &EnvelopeSpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		before := time.Now()
+		peer := sendFrom(server, "<34>1 - host app - - - hello")
+
+		Eventually(func() int {
+			return len(writer.Envelopes())
+		}).Should(Equal(1))
+
+		envelope := writer.Envelopes()[0]
+		Expect(envelope.Peer.String()).To(Equal(peer.String()))
+		Expect(envelope.Local.String()).To(Equal(server.Addr().String()))
+		Expect(envelope.Transport).To(Equal("udp"))
+		Expect(envelope.ReceivedAt).To(BeTemporally(">=", before))
+		Expect(envelope.TLS).To(BeNil())
+		Expect(writer.Logs()[0].Hostname()).To(Equal("host"))
+	})
+
+	It("keeps the envelope of spilled messages", func() {
+		writer := &EnvelopeSpyWriter{gate: make(chan struct{})}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			QueueSize: 1,
+			Workers:   1,
+			BatchSize: 1,
+			Overflow:  transports.Spill,
+			SpillDir:  GinkgoT().TempDir(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		peers := []string{sendFrom(server, "<34>1 - host app - - - hello").String()}
+		Eventually(func() int {
+			return len(writer.Envelopes())
+		}).Should(Equal(1))
+
+		for i := 1; i < 20; i++ {
+			peers = append(peers, sendFrom(server, "<34>1 - host app - - - hello").String())
+		}
+		Eventually(func() uint64 {
+			return server.Stats().Spilled
+		}).Should(BeNumerically(">=", 1))
+		close(writer.gate)
+
+		Eventually(func() int {
+			return len(writer.Envelopes())
+		}).Should(Equal(20))
+
+		for _, envelope := range writer.Envelopes() {
+			Expect(peers).To(ContainElement(envelope.Peer.String()))
+			Expect(envelope.ReceivedAt.IsZero()).To(BeFalse())
+		}
+	})
+
+	Context("when the hostname is empty", func() {
+		It("is left empty by default", func() {
+			writer := &SpyWriter{}
+			server, err := transports.NewUDPServer(0, writer, transports.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			go server.Start()
+			defer server.Close()
+
+			sendFrom(server, "<34>1 - - app - - - hello")
+
+			Eventually(func() int {
+				return len(writer.Logs())
+			}).Should(Equal(1))
+			Expect(writer.Logs()[0].Hostname()).To(BeEmpty())
+		})
+
+		It("can be filled from the peer IP", func() {
+			writer := &SpyWriter{}
+			server, err := transports.NewUDPServer(0, writer, transports.Options{
+				FillHostname: transports.HostnameFromPeerIP,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			go server.Start()
+			defer server.Close()
+
+			sendFrom(server, "<34>1 - - app - - - hello")
+
+			Eventually(func() int {
+				return len(writer.Logs())
+			}).Should(Equal(1))
+			Expect(writer.Logs()[0].Hostname()).To(Equal("127.0.0.1"))
+			Expect(writer.Logs()[0].String()).To(Equal("<34>1 - 127.0.0.1 app - - - hello"))
+		})
+
+		It("can be filled from reverse DNS", func() {
+			expected := "127.0.0.1"
+			if names, err := net.LookupAddr(expected); err == nil && len(names) > 0 {
+				expected = strings.TrimSuffix(names[0], ".")
+			}
+
+			writer := &SpyWriter{}
+			server, err := transports.NewUDPServer(0, writer, transports.Options{
+				FillHostname: transports.HostnameFromReverseDNS,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			go server.Start()
+			defer server.Close()
+
+			sendFrom(server, "<34>1 - sent app - - - hello")
+			Eventually(func() int {
+				return len(writer.Logs())
+			}).Should(Equal(1))
+			Expect(writer.Logs()[0].Hostname()).To(Equal("sent"))
+
+			// the first messages from a peer go out as sent while its
+			// lookup runs in the background
+			Eventually(func() string {
+				sent := len(writer.Logs())
+				sendFrom(server, "<34>1 - - app - - - hello")
+				Eventually(func() int {
+					return len(writer.Logs())
+				}).Should(Equal(sent + 1))
+				logs := writer.Logs()
+				return logs[sent].Hostname()
+			}).Should(Equal(expected))
+		})
+	})
+})
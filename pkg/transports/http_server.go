@@ -0,0 +1,306 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// HTTPServer accepts syslog messages in POST requests, for senders that
+// can't use UDP. Each request is written to the writer as one batch.
+//
+// Bodies are read based on their Content-Type:
+//   - application/logplex-1: octet-counted frames, as sent by logplex drains,
+//     which may leave out the structured data
+//   - application/json: a JSON array of RFC 5424 messages
+//   - anything else: RFC 5424 messages, one per line
+type HTTPServer struct {
+	*receiver
+	listener net.Listener
+	server   *http.Server
+}
+
+// HTTPResult is the response body for every accepted request.
+type HTTPResult struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Dropped  int               `json:"dropped"`
+	Errors   []HTTPResultError `json:"errors,omitempty"`
+}
+
+type HTTPResultError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func NewHTTPServer(port int, w Writer, options Options) (*HTTPServer, error) {
+	options = options.withDefaults()
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("could not start server: %s", err)
+	}
+
+	s := &HTTPServer{
+		receiver: newReceiver("http", w, options),
+		listener: listener,
+	}
+	s.server = &http.Server{
+		Handler:     s,
+		ReadTimeout: time.Minute,
+	}
+	return s, nil
+}
+
+func (s *HTTPServer) Start() error {
+	log.Printf("http: starting server on addr %s", s.Addr().String())
+
+	var err error
+	if s.options.TLSConfig != nil {
+		s.server.TLSConfig = s.options.TLSConfig
+		err = s.server.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.server.Serve(s.listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	flushErr := s.writer.Flush(ctx)
+	if err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	envelope := Envelope{
+		Peer:       parseTCPAddr(r.RemoteAddr),
+		Transport:  "http",
+		ReceivedAt: time.Now(),
+		TLS:        NewTLSIdentity(r.TLS),
+	}
+	if r.TLS != nil {
+		envelope.Transport = "https"
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		envelope.Local = local
+	}
+
+	switch s.admit(envelope.Peer) {
+	case dropDenied:
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	case dropRateLimited:
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.options.MaxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	s.metrics.bytes.Add(float64(len(body)))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var payloads [][]byte
+	switch mediaType {
+	case "application/logplex-1":
+		payloads, err = splitOctetCounted(body)
+		for i, payload := range payloads {
+			payloads[i] = logplexFrame(payload)
+		}
+	case "application/json":
+		payloads, err = splitJSON(body)
+	default:
+		payloads = splitLines(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := HTTPResult{}
+	logs := make([]*syslog.Log, 0, len(payloads))
+	envelopes := make([]Envelope, 0, len(payloads))
+	for i, payload := range payloads {
+		s.metrics.received.Inc()
+
+		parsed, err := s.parse(payload, envelope)
+		switch {
+		case err != nil:
+			result.Rejected++
+			result.Errors = append(result.Errors, HTTPResultError{Index: i, Error: err.Error()})
+		case parsed == nil:
+			result.Dropped++
+		default:
+			logs = append(logs, parsed)
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	if len(logs) > 0 {
+		ctx, cancel := s.writeContext(r)
+		defer cancel()
+
+		start := time.Now()
+		err = s.writer.WriteBatch(WithEnvelopes(ctx, envelopes), logs)
+		s.metrics.writeDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			s.metrics.writeErrors.Inc()
+			log.Printf("could not write %d messages: %s", len(logs), err)
+			http.Error(w, "could not store messages", http.StatusServiceUnavailable)
+			return
+		}
+		result.Accepted = len(logs)
+	}
+
+	status := http.StatusOK
+	switch {
+	case result.Rejected > 0 && result.Accepted == 0:
+		status = http.StatusBadRequest
+	case result.Rejected > 0:
+		status = http.StatusMultiStatus
+	case result.Dropped > 0 && result.Accepted == 0:
+		status = http.StatusTooManyRequests
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) writeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.options.WriteTimeout > 0 {
+		return context.WithTimeout(r.Context(), s.options.WriteTimeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+func (s *HTTPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *HTTPServer) Close() error {
+	return s.server.Close()
+}
+
+// splitOctetCounted splits frames of "<length> <message>", as used by
+// logplex and RFC 6587.
+func splitOctetCounted(body []byte) ([][]byte, error) {
+	payloads := [][]byte{}
+	for len(bytes.TrimSpace(body)) > 0 {
+		body = bytes.TrimLeft(body, " \r\n")
+
+		space := bytes.IndexByte(body, ' ')
+		if space <= 0 {
+			return nil, errors.New("invalid frame: missing length")
+		}
+		length, err := strconv.Atoi(string(body[:space]))
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid frame length %q", body[:space])
+		}
+
+		body = body[space+1:]
+		if length > len(body) {
+			return nil, fmt.Errorf("frame length %d exceeds remaining body of %d bytes", length, len(body))
+		}
+		payloads = append(payloads, body[:length])
+		body = body[length:]
+	}
+	return payloads, nil
+}
+
+// logplexFrame makes a frame from a logplex drain RFC 5424, which logplex
+// skips the STRUCTURED-DATA of, going from the MSGID straight to the MSG
+// as in "<40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed",
+// and ends with a newline. An empty STRUCTURED-DATA is added.
+func logplexFrame(payload []byte) []byte {
+	payload = bytes.TrimSuffix(payload, []byte("\n"))
+
+	// the STRUCTURED-DATA follows PRI and VERSION, TIMESTAMP, HOSTNAME,
+	// APP-NAME, PROCID and MSGID
+	offset := 0
+	for i := 0; i < 6; i++ {
+		space := bytes.IndexByte(payload[offset:], ' ')
+		if space < 0 {
+			return payload
+		}
+		offset += space + 1
+	}
+
+	rest := payload[offset:]
+	if bytes.Equal(rest, []byte("-")) || bytes.HasPrefix(rest, []byte("- ")) {
+		return payload
+	}
+	// messages often start with a bracket, as in "[INFO] booted", so only
+	// an element with params is taken to be STRUCTURED-DATA
+	if end := bytes.IndexByte(rest, ']'); bytes.HasPrefix(rest, []byte("[")) && end > 0 && bytes.Contains(rest[:end], []byte("=\"")) {
+		if _, _, err := syslog.Parse(payload); err == nil {
+			return payload
+		}
+	}
+
+	framed := make([]byte, 0, len(payload)+2)
+	framed = append(framed, payload[:offset]...)
+	framed = append(framed, "- "...)
+	return append(framed, rest...)
+}
+
+func splitJSON(body []byte) ([][]byte, error) {
+	var messages []string
+	err := json.Unmarshal(body, &messages)
+	if err != nil {
+		return nil, fmt.Errorf("expected a JSON array of strings: %s", err)
+	}
+
+	payloads := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		payloads = append(payloads, []byte(message))
+	}
+	return payloads, nil
+}
+
+func splitLines(body []byte) [][]byte {
+	payloads := [][]byte{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+		payloads = append(payloads, line)
+	}
+	return payloads
+}
+
+func parseTCPAddr(address string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
@@ -0,0 +1,223 @@
+package transports_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type FailingWriter struct{}
+
+func (FailingWriter) Write(*syslog.Log) error {
+	return errors.New("disk full")
+}
+
+func logplex(messages ...string) string {
+	body := ""
+	for _, message := range messages {
+		body += fmt.Sprintf("%d %s", len(message), message)
+	}
+	return body
+}
+
+var _ = Describe("HTTPServer", func() {
+	var (
+		writer *EnvelopeSpyWriter
+		server *transports.HTTPServer
+	)
+
+	newServer := func(w transports.Writer, options transports.Options) *transports.HTTPServer {
+		server, err := transports.NewHTTPServer(0, w, options)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+		return server
+	}
+
+	post := func(handler http.Handler, contentType, body string) (*httptest.ResponseRecorder, transports.HTTPResult) {
+		request := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		result := transports.HTTPResult{}
+		if recorder.Header().Get("Content-Type") == "application/json" {
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &result)).To(Succeed())
+		}
+		return recorder, result
+	}
+
+	BeforeEach(func() {
+		writer = &EnvelopeSpyWriter{}
+		server = newServer(writer, transports.Options{})
+	})
+
+	It("accepts logplex octet-counted bodies", func() {
+		recorder, result := post(server, "application/logplex-1", logplex(
+			"<34>1 2003-10-11T22:14:15.003Z host app web.1 - - first",
+			"<34>1 2003-10-11T22:14:15.003Z host app web.1 - - second\nwith a newline",
+		))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result).To(Equal(transports.HTTPResult{Accepted: 2}))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first", "second\nwith a newline"}))
+	})
+
+	It("accepts logplex frames without structured data", func() {
+		// as captured from a Heroku drain
+		body := "83 <40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up\n" +
+			"119 <40>1 2012-11-30T06:45:26+00:00 host app web.3 - Starting process with command `bundle exec rackup config.ru -p 24405`\n" +
+			"72 <190>1 2012-11-30T06:45:30+00:00 host app web.3 - [INFO] booted in 1.2s\n" +
+			"61 <190>1 2012-11-30T06:45:31+00:00 host app router - - at=info\n"
+		recorder, result := post(server, "application/logplex-1", body)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result).To(Equal(transports.HTTPResult{Accepted: 4}))
+		Expect(messages(writer.Logs())).To(Equal([]string{
+			"State changed from starting to up",
+			"Starting process with command `bundle exec rackup config.ru -p 24405`",
+			"[INFO] booted in 1.2s",
+			"at=info",
+		}))
+		Expect(writer.Logs()[0].Appname()).To(Equal("app"))
+		Expect(writer.Logs()[0].ProcID()).To(Equal("web.3"))
+		Expect(writer.Logs()[0].MsgID()).To(BeEmpty())
+	})
+
+	It("keeps the structured data of logplex frames that have it", func() {
+		recorder, result := post(server, "application/logplex-1", logplex(
+			`<34>1 2003-10-11T22:14:15.003Z host app web.1 - [meta dyno="web.1"] first`,
+		))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result).To(Equal(transports.HTTPResult{Accepted: 1}))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first"}))
+		dyno, _ := writer.Logs()[0].Param("meta", "dyno")
+		Expect(dyno).To(Equal("web.1"))
+	})
+
+	It("accepts newline delimited messages", func() {
+		recorder, result := post(server, "text/plain", "<34>1 - host app - - - first\r\n\n<34>1 - host app - - - second\n")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result.Accepted).To(Equal(2))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first", "second"}))
+	})
+
+	It("accepts JSON arrays of messages", func() {
+		recorder, result := post(server, "application/json; charset=utf-8", `["<34>1 - host app - - - first", "<34>1 - host app - - - second"]`)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(result.Accepted).To(Equal(2))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first", "second"}))
+	})
+
+	It("passes the envelope to the writer as one batch", func() {
+		post(server, "text/plain", "<34>1 - - app - - - first\n<34>1 - - app - - - second")
+
+		Expect(writer.Envelopes()).To(HaveLen(2))
+		envelope := writer.Envelopes()[0]
+		Expect(envelope.Transport).To(Equal("http"))
+		Expect(envelope.Peer.String()).To(Equal("192.0.2.1:1234"))
+		Expect(envelope.ReceivedAt.IsZero()).To(BeFalse())
+	})
+
+	It("reports messages that fail to parse", func() {
+		letters := &DeadLetterSpy{}
+		server := newServer(writer, transports.Options{DeadLetters: letters})
+
+		recorder, result := post(server, "text/plain", "<34>1 - host app - - - first\nnot syslog")
+
+		Expect(recorder.Code).To(Equal(http.StatusMultiStatus))
+		Expect(result).To(Equal(transports.HTTPResult{
+			Accepted: 1,
+			Rejected: 1,
+			Errors:   []transports.HTTPResultError{{Index: 1, Error: "could not parse message"}},
+		}))
+		Expect(letters.Letters()).To(HaveLen(1))
+		Expect(letters.Letters()[0].Transport).To(Equal("http"))
+	})
+
+	It("fails the batch when nothing parses", func() {
+		recorder, result := post(server, "text/plain", "not syslog")
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(result.Rejected).To(Equal(1))
+		Expect(writer.Logs()).To(BeEmpty())
+	})
+
+	It("rejects malformed frames", func() {
+		recorder, _ := post(server, "application/logplex-1", "100 <34>1 - host app - - - short")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+		recorder, _ = post(server, "application/json", `{"not": "an array"}`)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("asks the sender to retry when the writer fails", func() {
+		server := newServer(FailingWriter{}, transports.Options{})
+
+		recorder, _ := post(server, "text/plain", "<34>1 - host app - - - first")
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("only allows POST", func() {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("limits the size of the body", func() {
+		server := newServer(writer, transports.Options{MaxBodySize: 10})
+
+		recorder, _ := post(server, "text/plain", "<34>1 - host app - - - first")
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("applies access lists and rate limits", func() {
+		deny, err := transports.ParseCIDRs([]string{"192.0.2.0/24"})
+		Expect(err).ToNot(HaveOccurred())
+		server := newServer(writer, transports.Options{Deny: deny})
+
+		recorder, _ := post(server, "text/plain", "<34>1 - host app - - - first")
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+
+		server = newServer(writer, transports.Options{
+			RateLimits: []transports.RateLimit{{Key: transports.RateLimitByPeerIP, Rate: 0.001, Burst: 1}},
+		})
+		recorder, _ = post(server, "text/plain", "<34>1 - host app - - - first")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		recorder, _ = post(server, "text/plain", "<34>1 - host app - - - second")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("serves requests once started", func() {
+		done := make(chan error)
+		go func() { done <- server.Start() }()
+
+		var response *http.Response
+		Eventually(func() error {
+			var err error
+			response, err = http.Post(
+				"http://"+server.Addr().String()+"/logs",
+				"application/logplex-1",
+				strings.NewReader(logplex("<34>1 - host app - - - first")),
+			)
+			return err
+		}).Should(Succeed())
+		response.Body.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first"}))
+
+		Expect(server.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
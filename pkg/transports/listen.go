@@ -0,0 +1,44 @@
+package transports
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+func listenUDP(port int, options Options) ([]net.PacketConn, error) {
+	config := net.ListenConfig{}
+	if options.Listeners > 1 {
+		config.Control = reusePort
+	}
+
+	listeners := []net.PacketConn{}
+	address := fmt.Sprintf(":%d", port)
+	for i := 0; i < options.Listeners; i++ {
+		listener, err := config.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+
+		if options.ReadBuffer > 0 {
+			err = listener.(*net.UDPConn).SetReadBuffer(options.ReadBuffer)
+			if err != nil {
+				closeAll(listeners)
+				return nil, fmt.Errorf("could not set read buffer: %s", err)
+			}
+		}
+
+		// when the port is 0, the rest must share the one picked for the first
+		address = listener.LocalAddr().String()
+	}
+
+	return listeners, nil
+}
+
+func closeAll(listeners []net.PacketConn) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
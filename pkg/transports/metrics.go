@@ -0,0 +1,76 @@
+package transports
+
+import (
+	"github.com/jtarchie/syslog/pkg/metrics"
+)
+
+const (
+	dropOverflow    = "overflow"
+	dropDenied      = "denied"
+	dropRateLimited = "rate_limited"
+	dropTruncated   = "truncated"
+)
+
+type receiverMetrics struct {
+	transport string
+
+	received      *metrics.Counter
+	bytes         *metrics.Counter
+	parseFailures *metrics.Counter
+	truncated     *metrics.Counter
+	dropped       *metrics.CounterVec
+	writeDuration *metrics.Histogram
+	writeErrors   *metrics.Counter
+	queueDepth    *metrics.GaugeVec
+}
+
+func newReceiverMetrics(registry *metrics.Registry, transport string) *receiverMetrics {
+	return &receiverMetrics{
+		transport: transport,
+		received: registry.Counter(
+			"syslog_received_total",
+			"Messages received, before any are dropped or parsed.",
+			"transport",
+		).With(transport),
+		bytes: registry.Counter(
+			"syslog_received_bytes_total",
+			"Bytes received.",
+			"transport",
+		).With(transport),
+		parseFailures: registry.Counter(
+			"syslog_parse_failures_total",
+			"Messages that could not be parsed.",
+			"transport",
+		).With(transport),
+		truncated: registry.Counter(
+			"syslog_datagrams_truncated_total",
+			"Datagrams larger than the maximum datagram size, rejected as truncated.",
+			"transport",
+		).With(transport),
+		dropped: registry.Counter(
+			"syslog_dropped_total",
+			"Messages dropped before reaching the writer, by reason.",
+			"transport", "reason",
+		),
+		writeDuration: registry.Histogram(
+			"syslog_writer_duration_seconds",
+			"Time taken to write a batch.",
+			nil,
+			"transport",
+		).With(transport),
+		writeErrors: registry.Counter(
+			"syslog_writer_errors_total",
+			"Batches the writer failed to write.",
+			"transport",
+		).With(transport),
+		queueDepth: registry.Gauge(
+			"syslog_queue_depth",
+			"Messages waiting for a worker.",
+			"transport", "listener",
+		),
+	}
+}
+
+func (m *receiverMetrics) drop(reason string) {
+	m.dropped.With(m.transport, reason).Inc()
+}
@@ -0,0 +1,73 @@
+package transports_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/metrics"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("exposes receiver metrics for scraping", func() {
+		registry := metrics.NewRegistry()
+		deny, err := transports.ParseCIDRs([]string{"10.0.0.0/8"})
+		Expect(err).ToNot(HaveOccurred())
+
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			Metrics:       registry,
+			Deny:          deny,
+			BatchInterval: time.Millisecond,
+			RateLimits: []transports.RateLimit{
+				{Key: transports.RateLimitByAppname, Rate: 0.001, Burst: 1},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Metrics()).To(BeIdenticalTo(registry))
+		go server.Start()
+		defer server.Close()
+
+		conn, err := net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		for _, payload := range []string{
+			"<34>1 - host app - - - hello",
+			"<34>1 - host app - - - limited",
+			"not syslog",
+		} {
+			_, err = conn.Write([]byte(payload))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Eventually(writer.Logs).Should(HaveLen(1))
+
+		web := httptest.NewServer(registry)
+		defer web.Close()
+
+		scrape := func() string {
+			response, err := http.Get(web.URL + "/metrics")
+			Expect(err).ToNot(HaveOccurred())
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return string(body)
+		}
+
+		Eventually(scrape).Should(And(
+			ContainSubstring(`syslog_received_total{transport="udp"} 3`+"\n"),
+			ContainSubstring(`syslog_received_bytes_total{transport="udp"} 68`+"\n"),
+			ContainSubstring(`syslog_parse_failures_total{transport="udp"} 1`+"\n"),
+			ContainSubstring(`syslog_dropped_total{transport="udp",reason="rate_limited"} 1`+"\n"),
+			ContainSubstring(`syslog_queue_depth{transport="udp",listener="`+server.Addr().String()+`"} 0`+"\n"),
+			ContainSubstring(`syslog_writer_duration_seconds_count{transport="udp"} 1`+"\n"),
+			ContainSubstring(`# TYPE syslog_writer_errors_total counter`),
+		))
+	})
+})
@@ -0,0 +1,139 @@
+package transports
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/metrics"
+)
+
+const (
+	defaultMaxDatagramSize = 8 * 1024
+	maxDatagramSize        = 64 * 1024
+	defaultWorkers         = 10
+	defaultQueueSize       = 10000
+	defaultReadBatchSize   = 32
+	defaultListeners       = 1
+	defaultBatchSize       = 100
+	defaultBatchInterval   = 100 * time.Millisecond
+	defaultMaxBodySize     = 5 * 1024 * 1024
+)
+
+// OverflowPolicy decides what happens to a message that arrives while the
+// queue between the receiver and its workers is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the message that just arrived.
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the longest queued message to make room.
+	DropOldest
+	// Block stops reading from the socket until there is room.
+	Block
+	// Spill writes messages to a file in SpillDir and feeds them back to
+	// the workers, in order, as room frees up.
+	Spill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	case Spill:
+		return "spill"
+	}
+	return "unknown"
+}
+
+type Options struct {
+	// MaxDatagramSize is the largest datagram, in bytes, that will be read.
+	// Larger ones are dropped as truncated. It defaults to 8KiB and is
+	// capped at 64KiB.
+	MaxDatagramSize int
+	// Workers is the number of goroutines parsing and writing messages.
+	Workers int
+	// QueueSize is the number of messages buffered for the workers.
+	QueueSize int
+	// Overflow is applied when the queue is full. Defaults to DropNewest.
+	Overflow OverflowPolicy
+	// SpillDir is where the Spill policy keeps its file. Defaults to the
+	// system temporary directory.
+	SpillDir string
+	// ReadBatchSize is the number of datagrams read per syscall. On linux
+	// this uses recvmmsg, elsewhere it degrades to one datagram per read.
+	// A size of 1 reads with a plain ReadFrom.
+	ReadBatchSize int
+	// Listeners is the number of sockets bound to the same port with
+	// SO_REUSEPORT, each with its own reader. Only supported on linux.
+	Listeners int
+	// ReadBuffer sets SO_RCVBUF on each socket. Zero keeps the OS default.
+	ReadBuffer int
+	// FillHostname fills in the hostname of messages that were sent
+	// without one, using the peer's address.
+	FillHostname HostnameSource
+	// BatchSize is the most messages handed to the writer at once.
+	BatchSize int
+	// BatchInterval is the longest a message waits for its batch to fill.
+	BatchInterval time.Duration
+	// WriteTimeout bounds each call to the writer. Zero means no timeout.
+	WriteTimeout time.Duration
+	// DeadLetters, when set, receives every message that fails to parse.
+	DeadLetters DeadLetterSink
+	// Allow, when not empty, only accepts messages from peers in these
+	// networks. Deny rejects peers in its networks and wins over Allow.
+	// Both are checked before a message is parsed.
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+	// RateLimits are token buckets applied per peer IP, hostname or
+	// appname. Peer limits are checked before a message is parsed.
+	RateLimits []RateLimit
+	// Metrics is where the receiver registers its metrics, labelled by
+	// transport. Servers of the same transport sharing a registry add to
+	// the same series, apart from the queue depth, which is labelled by
+	// listener too. Defaults to a new registry.
+	Metrics *metrics.Registry
+	// MaxBodySize is the largest request body the HTTP transport accepts.
+	// It defaults to 5MiB.
+	MaxBodySize int64
+	// TLSConfig, when set, makes the HTTP transport serve HTTPS.
+	TLSConfig *tls.Config
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxDatagramSize <= 0 {
+		o.MaxDatagramSize = defaultMaxDatagramSize
+	}
+	if o.MaxDatagramSize > maxDatagramSize {
+		o.MaxDatagramSize = maxDatagramSize
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaultWorkers
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultQueueSize
+	}
+	if o.ReadBatchSize <= 0 {
+		o.ReadBatchSize = defaultReadBatchSize
+	}
+	if o.Listeners <= 0 {
+		o.Listeners = defaultListeners
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.BatchInterval <= 0 {
+		o.BatchInterval = defaultBatchInterval
+	}
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = defaultMaxBodySize
+	}
+	if o.Metrics == nil {
+		o.Metrics = metrics.NewRegistry()
+	}
+	return o
+}
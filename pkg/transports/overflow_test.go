@@ -0,0 +1,198 @@
+package transports_test
+
+import (
+	"net"
+	"os"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// GatedWriter records each log and then blocks until the gate is opened,
+// which keeps its worker busy and lets the queue fill up.
+type GatedWriter struct {
+	SpyWriter
+	gate chan struct{}
+}
+
+func (g *GatedWriter) Write(log *syslog.Log) error {
+	g.SpyWriter.Write(log)
+	<-g.gate
+	return nil
+}
+
+func messages(logs []*syslog.Log) []string {
+	values := []string{}
+	for _, log := range logs {
+		values = append(values, log.Message())
+	}
+	return values
+}
+
+var _ = Describe("Overflow", func() {
+	var (
+		writer  *GatedWriter
+		server  *transports.UDPServer
+		conn    net.Conn
+		done    chan error
+		stopped chan struct{}
+	)
+
+	send := func(message string) {
+		_, err := conn.Write([]byte("<34>1 - - - - - - " + message))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	start := func(policy transports.OverflowPolicy) {
+		writer = &GatedWriter{gate: make(chan struct{})}
+
+		var err error
+		server, err = transports.NewUDPServer(0, writer, transports.Options{
+			Workers:   1,
+			QueueSize: 1,
+			BatchSize: 1,
+			Overflow:  policy,
+			SpillDir:  GinkgoT().TempDir(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		// the goroutine keeps its own server and channels, so it can't race
+		// with, or answer for, the next spec's
+		done, stopped = make(chan error, 1), make(chan struct{})
+		go func(server *transports.UDPServer, done chan error, stopped chan struct{}) {
+			defer close(stopped)
+			done <- server.Start()
+		}(server, done, stopped)
+
+		conn, err = net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+
+		send("first")
+		Eventually(func() []string {
+			return messages(writer.Logs())
+		}).Should(Equal([]string{"first"}))
+
+		send("second")
+		Eventually(func() int {
+			return server.Stats().Queued
+		}).Should(Equal(1))
+	}
+
+	AfterEach(func() {
+		conn.Close()
+		select {
+		case <-writer.gate:
+		default:
+			close(writer.gate)
+		}
+		server.Close()
+		<-stopped
+	})
+
+	It("drops the newest message", func() {
+		start(transports.DropNewest)
+
+		send("third")
+		Eventually(func() uint64 {
+			return server.Stats().Dropped
+		}).Should(BeEquivalentTo(1))
+
+		close(writer.gate)
+		Eventually(func() []string {
+			return messages(writer.Logs())
+		}).Should(Equal([]string{"first", "second"}))
+		Consistently(func() []string {
+			return messages(writer.Logs())
+		}, "100ms").Should(Equal([]string{"first", "second"}))
+	})
+
+	It("drops the oldest message", func() {
+		start(transports.DropOldest)
+
+		send("third")
+		Eventually(func() uint64 {
+			return server.Stats().Dropped
+		}).Should(BeEquivalentTo(1))
+
+		close(writer.gate)
+		Eventually(func() []string {
+			return messages(writer.Logs())
+		}).Should(Equal([]string{"first", "third"}))
+	})
+
+	It("blocks until there is room", func() {
+		start(transports.Block)
+
+		send("third")
+		send("fourth")
+		Eventually(func() uint64 {
+			return server.Stats().Received
+		}).Should(BeEquivalentTo(3))
+
+		close(writer.gate)
+		Eventually(func() []string {
+			return messages(writer.Logs())
+		}).Should(Equal([]string{"first", "second", "third", "fourth"}))
+		Expect(server.Stats().Dropped).To(BeEquivalentTo(0))
+	})
+
+	It("spills to disk and replays in order", func() {
+		start(transports.Spill)
+
+		send("third")
+		send("fourth")
+		Eventually(func() uint64 {
+			return server.Stats().Spilled
+		}).Should(BeEquivalentTo(2))
+
+		close(writer.gate)
+		Eventually(func() []string {
+			return messages(writer.Logs())
+		}).Should(Equal([]string{"first", "second", "third", "fourth"}))
+		Expect(server.Stats().Dropped).To(BeEquivalentTo(0))
+	})
+
+	It("delivers what was spilled before stopping", func() {
+		start(transports.Spill)
+
+		send("third")
+		send("fourth")
+		Eventually(func() uint64 {
+			return server.Stats().Spilled
+		}).Should(BeEquivalentTo(2))
+
+		Expect(server.Close()).To(Succeed())
+		Consistently(done, "100ms").ShouldNot(Receive())
+
+		close(writer.gate)
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(messages(writer.Logs())).To(Equal([]string{"first", "second", "third", "fourth"}))
+		Expect(server.Stats().Dropped).To(BeEquivalentTo(0))
+	})
+})
+
+var _ = Describe("Spill", func() {
+	It("removes the spill file on close", func() {
+		dir := GinkgoT().TempDir()
+		server, err := transports.NewUDPServer(0, &SpyWriter{}, transports.Options{
+			Overflow: transports.Spill,
+			SpillDir: dir,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		done := make(chan error)
+		go func() { done <- server.Start() }()
+
+		Eventually(func() int {
+			entries, _ := os.ReadDir(dir)
+			return len(entries)
+		}).Should(Equal(1))
+
+		Expect(server.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+})
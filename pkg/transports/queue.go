@@ -0,0 +1,177 @@
+package transports
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// queue hands packets from the reader to the workers, applying the
+// overflow policy when the workers can't keep up.
+type queue struct {
+	packets chan packet
+	policy  OverflowPolicy
+	buffers *sync.Pool
+	spill   *spill
+	done    chan struct{}
+	metrics *receiverMetrics
+
+	received atomic.Uint64
+	dropped  atomic.Uint64
+	spilled  atomic.Uint64
+}
+
+func newQueue(options Options, buffers *sync.Pool, metrics *receiverMetrics) (*queue, error) {
+	q := &queue{
+		packets: make(chan packet, options.QueueSize),
+		policy:  options.Overflow,
+		buffers: buffers,
+		done:    make(chan struct{}),
+		metrics: metrics,
+	}
+
+	if q.policy != Spill {
+		close(q.done)
+		return q, nil
+	}
+
+	spill, err := newSpill(options.SpillDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not create spill file: %s", err)
+	}
+	q.spill = spill
+	go q.drain()
+
+	return q, nil
+}
+
+func (q *queue) push(p packet) {
+	q.received.Add(1)
+
+	switch q.policy {
+	case Block:
+		q.packets <- p
+		return
+	case DropOldest:
+		for {
+			select {
+			case q.packets <- p:
+				return
+			default:
+			}
+			select {
+			case old := <-q.packets:
+				q.release(old)
+				q.drop()
+			default:
+			}
+		}
+	case Spill:
+		// once anything is spilled, newer packets go behind it to keep order
+		if q.spill.len() == 0 {
+			select {
+			case q.packets <- p:
+				return
+			default:
+			}
+		}
+		err := q.spill.write(p)
+		q.release(p)
+		if err != nil {
+			log.Printf("udp: could not spill message: %s", err)
+			q.drop()
+			return
+		}
+		q.spilled.Add(1)
+	default:
+		select {
+		case q.packets <- p:
+		default:
+			q.release(p)
+			q.drop()
+		}
+	}
+}
+
+// drain feeds spilled packets back to the workers as room frees up, until
+// the spill is closed and empty. When the file can't be read, what is left
+// in it is dropped rather than read again in a loop.
+func (q *queue) drain() {
+	defer close(q.done)
+
+	for {
+		p := packet{buffer: q.buffers.Get().(*[]byte)}
+		err := q.spill.read(&p)
+		if err == errSpillClosed {
+			q.release(p)
+			return
+		}
+		if err != nil {
+			q.release(p)
+			lost := 1
+			if !errors.Is(err, errSpillRecord) {
+				lost = q.spill.discard()
+			}
+			log.Printf("udp: could not read spilled message, dropping %d: %s", lost, err)
+			for i := 0; i < lost; i++ {
+				q.drop()
+			}
+			continue
+		}
+		q.packets <- p
+	}
+}
+
+func (q *queue) drop() {
+	q.metrics.drop(dropOverflow)
+	dropped := q.dropped.Add(1)
+	if dropped%1000 == 0 {
+		log.Printf("udp: unable to proccess %d/%d messages with %d in queue", dropped, q.received.Load(), len(q.packets))
+	}
+}
+
+func (q *queue) release(p packet) {
+	q.buffers.Put(p.buffer)
+}
+
+// close stops accepting packets and waits for anything spilled to be
+// handed to the workers. Workers ranging over the packets see the channel
+// close once everything queued has been handled.
+func (q *queue) close() {
+	if q.spill != nil {
+		q.spill.close()
+		<-q.done
+
+		left, err := q.spill.remove()
+		if err != nil {
+			log.Printf("udp: could not remove spill file: %s", err)
+		}
+		for i := 0; i < left; i++ {
+			q.drop()
+		}
+	}
+	<-q.done
+	close(q.packets)
+}
+
+type Stats struct {
+	Received uint64
+	Dropped  uint64
+	Spilled  uint64
+	Queued   int
+	// Denied counts messages rejected by the Allow and Deny lists.
+	Denied uint64
+	// RateLimited counts drops keyed by "<peer|hostname|appname>:<value>".
+	RateLimited map[string]uint64
+}
+
+func (q *queue) stats() Stats {
+	return Stats{
+		Received: q.received.Load(),
+		Dropped:  q.dropped.Load(),
+		Spilled:  q.spilled.Load(),
+		Queued:   len(q.packets),
+	}
+}
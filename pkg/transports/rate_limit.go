@@ -0,0 +1,165 @@
+package transports
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// RateLimitKey is what messages are grouped by when rate limiting.
+type RateLimitKey int
+
+const (
+	RateLimitByPeerIP RateLimitKey = iota
+	RateLimitByHostname
+	RateLimitByAppname
+)
+
+func (k RateLimitKey) String() string {
+	switch k {
+	case RateLimitByPeerIP:
+		return "peer"
+	case RateLimitByHostname:
+		return "hostname"
+	case RateLimitByAppname:
+		return "appname"
+	}
+	return "unknown"
+}
+
+type RateLimit struct {
+	Key RateLimitKey
+	// Rate is the number of messages per second allowed for each key.
+	Rate float64
+	// Burst is the number of messages allowed at once for each key. It
+	// defaults to the Rate rounded up, and at least 1.
+	Burst int
+}
+
+func (l RateLimit) withDefaults() RateLimit {
+	if l.Burst <= 0 {
+		l.Burst = max(1, int(math.Ceil(l.Rate)))
+	}
+	return l
+}
+
+const (
+	// maxRateLimitKeys bounds the memory used by spoofed or churning keys.
+	// Past it, the least recently seen keys are forgotten and start over
+	// with a full burst.
+	maxRateLimitKeys  = 100000
+	otherRateLimitKey = "other"
+)
+
+// RateLimiter is a token bucket per key.
+type RateLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	buckets *lru[string, *bucket]
+	dropped map[string]uint64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit.withDefaults(),
+		buckets: newLRU[string, *bucket](maxRateLimitKeys),
+		dropped: map[string]uint64{},
+	}
+}
+
+func (r *RateLimiter) Allow(key string) bool {
+	now := time.Now()
+	burst := float64(r.limit.Burst)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.buckets.get(key)
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		r.buckets.add(key, b)
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*r.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+
+	if _, ok := r.dropped[key]; !ok && len(r.dropped) >= maxRateLimitKeys {
+		key = otherRateLimitKey
+	}
+	r.dropped[key]++
+	return false
+}
+
+// Dropped returns the number of messages dropped for each key.
+func (r *RateLimiter) Dropped() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dropped := make(map[string]uint64, len(r.dropped))
+	for key, count := range r.dropped {
+		dropped[key] = count
+	}
+	return dropped
+}
+
+type rateLimiters []*RateLimiter
+
+func newRateLimiters(limits []RateLimit) rateLimiters {
+	limiters := rateLimiters{}
+	for _, limit := range limits {
+		limiters = append(limiters, NewRateLimiter(limit))
+	}
+	return limiters
+}
+
+// allowPeer applies the peer limits, which run before parsing.
+func (r rateLimiters) allowPeer(ip string) bool {
+	for _, limiter := range r {
+		if limiter.limit.Key == RateLimitByPeerIP && !limiter.Allow(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowLog applies the limits that need the parsed message.
+func (r rateLimiters) allowLog(l *syslog.Log) bool {
+	for _, limiter := range r {
+		var allowed bool
+		switch limiter.limit.Key {
+		case RateLimitByHostname:
+			allowed = limiter.Allow(l.Hostname())
+		case RateLimitByAppname:
+			allowed = limiter.Allow(l.Appname())
+		default:
+			continue
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// dropped returns the drops of every limiter, keyed as "<key kind>:<key>".
+func (r rateLimiters) dropped() map[string]uint64 {
+	dropped := map[string]uint64{}
+	for _, limiter := range r {
+		for key, count := range limiter.Dropped() {
+			dropped[limiter.limit.Key.String()+":"+key] += count
+		}
+	}
+	return dropped
+}
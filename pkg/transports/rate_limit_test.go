@@ -0,0 +1,195 @@
+package transports_test
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	It("allows a burst and then refills at the rate", func() {
+		limiter := transports.NewRateLimiter(transports.RateLimit{Rate: 50, Burst: 2})
+
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeFalse())
+		Expect(limiter.Allow("b")).To(BeTrue())
+
+		Eventually(func() bool {
+			return limiter.Allow("a")
+		}, "100ms", "5ms").Should(BeTrue())
+
+		Expect(limiter.Dropped()["a"]).To(BeNumerically(">=", 1))
+		Expect(limiter.Dropped()).ToNot(HaveKey("b"))
+	})
+
+	It("defaults the burst to the rate", func() {
+		limiter := transports.NewRateLimiter(transports.RateLimit{Rate: 2.5})
+
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeFalse())
+
+		limiter = transports.NewRateLimiter(transports.RateLimit{Rate: 0.1})
+		Expect(limiter.Allow("a")).To(BeTrue())
+		Expect(limiter.Allow("a")).To(BeFalse())
+	})
+
+	It("forgets the least recently seen keys once it holds too many", func() {
+		limiter := transports.NewRateLimiter(transports.RateLimit{Rate: 0.001, Burst: 1})
+
+		Expect(limiter.Allow("first")).To(BeTrue())
+		Expect(limiter.Allow("first")).To(BeFalse())
+		for i := 0; i < 100000; i++ {
+			limiter.Allow(fmt.Sprintf("key-%d", i))
+		}
+		Expect(limiter.Allow("first")).To(BeTrue())
+		Expect(limiter.Allow("key-99999")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Receiving with limits", func() {
+	start := func(options transports.Options) (*SpyWriter, *transports.UDPServer, net.Conn) {
+		options.BatchInterval = time.Millisecond
+
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, options)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		DeferCleanup(server.Close)
+
+		addr := server.Addr().(*net.UDPAddr)
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: addr.Port})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(conn.Close)
+
+		return writer, server, conn
+	}
+
+	send := func(conn net.Conn, payload string, count int) {
+		for i := 0; i < count; i++ {
+			_, err := conn.Write([]byte(payload))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	It("rate limits by peer IP", func() {
+		writer, server, conn := start(transports.Options{
+			RateLimits: []transports.RateLimit{
+				{Key: transports.RateLimitByPeerIP, Rate: 0.001, Burst: 3},
+			},
+		})
+
+		send(conn, "<34>1 - host app - - - hello", 10)
+
+		Eventually(func() uint64 {
+			return server.Stats().RateLimited["peer:127.0.0.1"]
+		}).Should(BeEquivalentTo(7))
+		Eventually(writer.Logs).Should(HaveLen(3))
+		Expect(server.Stats().Received).To(BeEquivalentTo(3))
+	})
+
+	It("rate limits by appname", func() {
+		writer, server, conn := start(transports.Options{
+			RateLimits: []transports.RateLimit{
+				{Key: transports.RateLimitByAppname, Rate: 0.001, Burst: 2},
+			},
+		})
+
+		send(conn, "<34>1 - host noisy - - - hello", 5)
+		send(conn, "<34>1 - host quiet - - - hello", 1)
+
+		Eventually(func() map[string]uint64 {
+			return server.Stats().RateLimited
+		}).Should(Equal(map[string]uint64{"appname:noisy": 3}))
+		Eventually(writer.Logs).Should(HaveLen(3))
+	})
+
+	It("rate limits by hostname", func() {
+		writer, server, conn := start(transports.Options{
+			RateLimits: []transports.RateLimit{
+				{Key: transports.RateLimitByHostname, Rate: 0.001, Burst: 1},
+			},
+		})
+
+		send(conn, "<34>1 - one app - - - hello", 2)
+		send(conn, "<34>1 - two app - - - hello", 2)
+
+		Eventually(func() map[string]uint64 {
+			return server.Stats().RateLimited
+		}).Should(Equal(map[string]uint64{"hostname:one": 1, "hostname:two": 1}))
+		Eventually(writer.Logs).Should(HaveLen(2))
+	})
+
+	Context("with access lists", func() {
+		It("rejects denied peers before parsing", func() {
+			deny, err := transports.ParseCIDRs([]string{"127.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+			letters := &DeadLetterSpy{}
+
+			writer, server, conn := start(transports.Options{
+				Deny:        deny,
+				DeadLetters: letters,
+			})
+
+			send(conn, "not syslog", 1)
+			send(conn, "<34>1 - host app - - - hello", 1)
+
+			Eventually(func() uint64 {
+				return server.Stats().Denied
+			}).Should(BeEquivalentTo(2))
+			Consistently(writer.Logs, "50ms").Should(BeEmpty())
+			Expect(letters.Letters()).To(BeEmpty())
+		})
+
+		It("only accepts allowed peers", func() {
+			allow, err := transports.ParseCIDRs([]string{"10.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+
+			writer, server, conn := start(transports.Options{Allow: allow})
+			send(conn, "<34>1 - host app - - - hello", 1)
+
+			Eventually(func() uint64 {
+				return server.Stats().Denied
+			}).Should(BeEquivalentTo(1))
+			Expect(writer.Logs()).To(BeEmpty())
+		})
+
+		It("lets deny win over allow", func() {
+			allow, err := transports.ParseCIDRs([]string{"127.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+			deny, err := transports.ParseCIDRs([]string{"127.0.0.1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, server, conn := start(transports.Options{Allow: allow, Deny: deny})
+			send(conn, "<34>1 - host app - - - hello", 1)
+
+			Eventually(func() uint64 {
+				return server.Stats().Denied
+			}).Should(BeEquivalentTo(1))
+		})
+
+		It("accepts allowed peers", func() {
+			allow, err := transports.ParseCIDRs([]string{"127.0.0.1", "::1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			writer, server, conn := start(transports.Options{Allow: allow})
+			send(conn, "<34>1 - host app - - - hello", 1)
+
+			Eventually(writer.Logs).Should(HaveLen(1))
+			Expect(server.Stats().Denied).To(BeEquivalentTo(0))
+		})
+
+		It("rejects invalid networks", func() {
+			_, err := transports.ParseCIDRs([]string{"10.0.0.0/99"})
+			Expect(err).To(HaveOccurred())
+			_, err = transports.ParseCIDRs([]string{"not-an-ip"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,91 @@
+package transports
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/metrics"
+)
+
+// receiver is what every transport does between reading a message off the
+// wire and handing it to the writer.
+type receiver struct {
+	transport string
+	writer    BatchWriter
+	options   Options
+	metrics   *receiverMetrics
+	hostnames *hostnameResolver
+	acl       acl
+	limiters  rateLimiters
+	denied    atomic.Uint64
+}
+
+func newReceiver(transport string, w Writer, options Options) *receiver {
+	return &receiver{
+		transport: transport,
+		writer:    NewBatchWriter(w),
+		options:   options,
+		metrics:   newReceiverMetrics(options.Metrics, transport),
+		hostnames: newHostnameResolver(options.FillHostname),
+		acl:       acl{allow: options.Allow, deny: options.Deny},
+		limiters:  newRateLimiters(options.RateLimits),
+	}
+}
+
+// admit checks the peer against the access lists and peer rate limits
+// before anything it sent is parsed. It returns the reason for a drop, or
+// an empty string.
+func (r *receiver) admit(peer net.Addr) string {
+	if !r.acl.permits(peer) {
+		r.denied.Add(1)
+		r.metrics.drop(dropDenied)
+		return dropDenied
+	}
+	if !r.limiters.allowPeer(peerIP(peer)) {
+		r.metrics.drop(dropRateLimited)
+		return dropRateLimited
+	}
+	return ""
+}
+
+// parse turns a payload into a log, recording failures as dead letters. It
+// returns nil when the message failed to parse or was rate limited.
+func (r *receiver) parse(payload []byte, envelope Envelope) (*syslog.Log, error) {
+	parsed, _, err := syslog.Parse(payload)
+	if err != nil {
+		r.metrics.parseFailures.Inc()
+		log.Printf("could not parse msg: %s", err)
+		r.deadLetter(payload, envelope, err)
+		return nil, err
+	}
+
+	r.hostnames.fill(parsed, envelope)
+	if !r.limiters.allowLog(parsed) {
+		r.metrics.drop(dropRateLimited)
+		return nil, nil
+	}
+	return parsed, nil
+}
+
+func (r *receiver) deadLetter(payload []byte, envelope Envelope, parseErr error) {
+	if r.options.DeadLetters == nil {
+		return
+	}
+
+	err := r.options.DeadLetters.WriteDeadLetter(DeadLetter{
+		Payload:    append([]byte(nil), payload...),
+		Peer:       addrString(envelope.Peer),
+		Transport:  envelope.Transport,
+		ReceivedAt: envelope.ReceivedAt,
+		Error:      parseErr.Error(),
+	})
+	if err != nil {
+		log.Printf("could not write dead letter: %s", err)
+	}
+}
+
+func (r *receiver) Metrics() *metrics.Registry {
+	return r.options.Metrics
+}
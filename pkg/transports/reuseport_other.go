@@ -0,0 +1,12 @@
+//go:build !linux
+
+package transports
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("multiple listeners require SO_REUSEPORT, which is only supported on linux")
+}
@@ -0,0 +1,193 @@
+package transports
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// errSpillClosed is returned by write once the spill is closed, and by
+// read once it is closed and every record has been read.
+var errSpillClosed = errors.New("spill is closed")
+
+// errSpillRecord is returned by read for a record that could not be
+// decoded. Unlike other errors, the record has been consumed.
+var errSpillRecord = errors.New("could not decode spilled record")
+
+// spill is a FIFO of packets kept in a file. Each record is a 4 byte
+// big-endian length followed by the JSON encoded spilledPacket.
+type spill struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	reads   int64
+	writes  int64
+	pending int
+	closed  bool
+}
+
+func newSpill(dir string) (*spill, error) {
+	file, err := os.CreateTemp(dir, "udp-spill-*")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spill{file: file}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+type spilledPacket struct {
+	Payload    []byte
+	Peer       string
+	Local      string
+	ReceivedAt time.Time
+}
+
+func (s *spill) write(p packet) error {
+	contents, err := json.Marshal(spilledPacket{
+		Payload:    (*p.buffer)[:p.n],
+		Peer:       addrString(p.peer),
+		Local:      addrString(p.local),
+		ReceivedAt: p.receivedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSpillClosed
+	}
+
+	record := make([]byte, 4+len(contents))
+	binary.BigEndian.PutUint32(record, uint32(len(contents)))
+	copy(record[4:], contents)
+
+	_, err = s.file.WriteAt(record, s.writes)
+	if err != nil {
+		return err
+	}
+	s.writes += int64(len(record))
+	s.pending++
+	s.cond.Signal()
+	return nil
+}
+
+// read blocks until a record is available and copies its payload into
+// the packet's buffer.
+func (s *spill) read(p *packet) error {
+	contents, err := s.next()
+	if err != nil {
+		return err
+	}
+
+	var spilled spilledPacket
+	err = json.Unmarshal(contents, &spilled)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSpillRecord, err)
+	}
+
+	p.n = copy(*p.buffer, spilled.Payload)
+	p.peer = parseUDPAddr(spilled.Peer)
+	p.local = parseUDPAddr(spilled.Local)
+	p.receivedAt = spilled.ReceivedAt
+	return nil
+}
+
+func (s *spill) next() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.pending == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.pending == 0 {
+		return nil, errSpillClosed
+	}
+
+	var header [4]byte
+	_, err := s.file.ReadAt(header[:], s.reads)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]byte, binary.BigEndian.Uint32(header[:]))
+
+	_, err = s.file.ReadAt(contents, s.reads+4)
+	if err != nil {
+		return nil, err
+	}
+	s.reads += 4 + int64(len(contents))
+	s.pending--
+
+	// once empty, writes start over from the beginning; truncating only
+	// gives the space back, so failing to is harmless
+	if s.pending == 0 {
+		s.reads, s.writes = 0, 0
+		_ = s.file.Truncate(0)
+	}
+	return contents, nil
+}
+
+func (s *spill) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending
+}
+
+// discard drops every pending record, returning how many there were, for
+// when the file can no longer be read.
+func (s *spill) discard() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	discarded := s.pending
+	s.pending = 0
+	s.reads, s.writes = 0, 0
+	_ = s.file.Truncate(0)
+	return discarded
+}
+
+// close stops writes. Records already written can still be read, until
+// remove.
+func (s *spill) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// remove deletes the file, returning how many records were never read.
+func (s *spill) remove() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+	s.file.Close()
+	return s.pending, os.Remove(s.file.Name())
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func parseUDPAddr(address string) net.Addr {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
@@ -0,0 +1,7 @@
+package transports
+
+import "golang.org/x/sys/unix"
+
+// msgTrunc is set in a read's flags when the datagram was larger than the
+// buffer it was read into.
+const msgTrunc = unix.MSG_TRUNC
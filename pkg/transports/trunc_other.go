@@ -0,0 +1,7 @@
+//go:build !linux
+
+package transports
+
+// msgTrunc is never reported here, so truncated datagrams can't be told
+// apart from others.
+const msgTrunc = 0
@@ -1,75 +1,221 @@
 package transports
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
-	"github.com/jtarchie/syslog/pkg/log"
+	"golang.org/x/net/ipv4"
 )
 
-type Writer interface {
-	Write(*syslog.Log) error
+// packet is a datagram read from the listener. The buffer is owned by
+// whoever holds the packet and must be returned to the pool once parsed.
+type packet struct {
+	buffer     *[]byte
+	n          int
+	peer       net.Addr
+	local      net.Addr
+	receivedAt time.Time
 }
 
 type UDPServer struct {
-	writer   Writer
-	listener net.PacketConn
+	*receiver
+	listeners []net.PacketConn
+	buffers   *sync.Pool
+	queue     *queue
 }
 
-func NewUDPServer(port int, w Writer) (*UDPServer, error) {
-	listener, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+func NewUDPServer(port int, w Writer, options Options) (*UDPServer, error) {
+	options = options.withDefaults()
+	listeners, err := listenUDP(port, options)
 	if err != nil {
 		return nil, fmt.Errorf("could not start server: %s", err)
 	}
+
+	buffers := &sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, options.MaxDatagramSize)
+			return &buffer
+		},
+	}
+
+	receiver := newReceiver("udp", w, options)
+	queue, err := newQueue(options, buffers, receiver.metrics)
+	if err != nil {
+		closeAll(listeners)
+		return nil, fmt.Errorf("could not start server: %s", err)
+	}
+	receiver.metrics.queueDepth.With("udp", listeners[0].LocalAddr().String()).SetFunc(func() float64 {
+		return float64(len(queue.packets))
+	})
+
 	return &UDPServer{
-		writer:   w,
-		listener: listener,
+		receiver:  receiver,
+		listeners: listeners,
+		buffers:   buffers,
+		queue:     queue,
 	}, nil
 }
 
 func (s *UDPServer) Start() error {
-	queue := make(chan []byte, 10000)
-
-	log.Printf("udp: starting server on addr %s", s.listener.LocalAddr().String())
-	defer s.listener.Close()
+	log.Printf("udp: starting server on addr %s with %d listener(s)", s.Addr().String(), len(s.listeners))
+	batcher := newBatcher(s.writer, s.options, s.metrics)
 
-	for i := 1; i <= 10; i++ {
+	workers := sync.WaitGroup{}
+	for i := 1; i <= s.options.Workers; i++ {
+		workers.Add(1)
 		go func() {
-			for {
-				select {
-				case buffer := <-queue:
-					parsed, _, err := syslog.Parse(buffer)
-					if err != nil {
-						log.Printf("could not parse msg: %s", err)
-						continue
-					}
-					s.writer.Write(parsed)
-				}
-			}
+			defer workers.Done()
+			s.work(s.queue.packets, batcher)
 		}()
 	}
 
-	buffer := make([]byte, 1024)
-	failed, total := 0, 0
+	wg := sync.WaitGroup{}
+	for _, listener := range s.listeners {
+		wg.Add(1)
+		go func(listener net.PacketConn) {
+			defer wg.Done()
+			defer listener.Close()
+
+			if s.options.ReadBatchSize > 1 {
+				s.readBatches(listener)
+			} else {
+				s.read(listener)
+			}
+		}(listener)
+	}
+	wg.Wait()
+
+	s.queue.close()
+	workers.Wait()
+	return batcher.close()
+}
+
+func (s *UDPServer) read(listener net.PacketConn) {
 	for {
-		n, _, err := s.listener.ReadFrom(buffer)
+		buffer := s.buffers.Get().(*[]byte)
+		// listeners are always UDP, and ReadMsgUDP reports truncation
+		n, _, flags, peer, err := listener.(*net.UDPConn).ReadMsgUDP(*buffer, nil)
 		if err != nil {
+			s.buffers.Put(buffer)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("could not read from UDP: %s", err)
 			continue
 		}
-		total += 1
-		select {
-		case queue <- buffer[:n]:
-		default:
-			failed += 1
-			if failed%1000 == 0 {
-				log.Printf("udp: unable to proccess %d/%d messages with %d in queue", failed, total, len(queue))
+		if !s.count(peer, n, flags) {
+			s.buffers.Put(buffer)
+			continue
+		}
+		s.queue.push(packet{
+			buffer:     buffer,
+			n:          n,
+			peer:       peer,
+			local:      listener.LocalAddr(),
+			receivedAt: time.Now(),
+		})
+	}
+}
+
+func (s *UDPServer) readBatches(listener net.PacketConn) {
+	// ReadBatch only depends on the socket, so this works for IPv6 too
+	reader := ipv4.NewPacketConn(listener)
+
+	messages := make([]ipv4.Message, s.options.ReadBatchSize)
+	buffers := make([]*[]byte, len(messages))
+	for i := range messages {
+		buffers[i] = s.buffers.Get().(*[]byte)
+		messages[i].Buffers = [][]byte{*buffers[i]}
+	}
+	defer func() {
+		for _, buffer := range buffers {
+			s.buffers.Put(buffer)
+		}
+	}()
+
+	for {
+		n, err := reader.ReadBatch(messages, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("could not read from UDP: %s", err)
+			continue
+		}
+
+		receivedAt := time.Now()
+		for i := 0; i < n; i++ {
+			// rejected datagrams leave their buffer in place for the next read
+			if !s.count(messages[i].Addr, messages[i].N, messages[i].Flags) {
+				continue
 			}
+			s.queue.push(packet{
+				buffer:     buffers[i],
+				n:          messages[i].N,
+				peer:       messages[i].Addr,
+				local:      listener.LocalAddr(),
+				receivedAt: receivedAt,
+			})
+			buffers[i] = s.buffers.Get().(*[]byte)
+			messages[i].Buffers[0] = *buffers[i]
 		}
 	}
 }
 
+func (s *UDPServer) work(queue <-chan packet, batcher *batcher) {
+	for p := range queue {
+		envelope := Envelope{
+			Peer:       p.peer,
+			Local:      p.local,
+			Transport:  "udp",
+			ReceivedAt: p.receivedAt,
+		}
+		parsed, _ := s.parse((*p.buffer)[:p.n], envelope)
+		s.buffers.Put(p.buffer)
+		if parsed != nil {
+			batcher.add(parsed, envelope)
+		}
+	}
+}
+
+// count records a datagram and whether it is admitted. Datagrams larger
+// than MaxDatagramSize are rejected, as what was read is only part of them.
+func (s *UDPServer) count(peer net.Addr, n int, flags int) bool {
+	s.metrics.received.Inc()
+	s.metrics.bytes.Add(float64(n))
+	if s.admit(peer) != "" {
+		return false
+	}
+	if flags&msgTrunc != 0 {
+		s.metrics.truncated.Inc()
+		s.metrics.drop(dropTruncated)
+		log.Printf("udp: dropped datagram from %s larger than %d bytes", addrString(peer), s.options.MaxDatagramSize)
+		return false
+	}
+	return true
+}
+
 func (s *UDPServer) Addr() net.Addr {
-	return s.listener.LocalAddr()
+	return s.listeners[0].LocalAddr()
+}
+
+func (s *UDPServer) Stats() Stats {
+	stats := s.queue.stats()
+	stats.Denied = s.denied.Load()
+	stats.RateLimited = s.limiters.dropped()
+	return stats
+}
+
+func (s *UDPServer) Close() error {
+	var err error
+	for _, listener := range s.listeners {
+		if closeErr := listener.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			err = closeErr
+		}
+	}
+	return err
 }
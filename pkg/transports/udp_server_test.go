@@ -1,12 +1,17 @@
 package transports_test
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/metrics"
 	"github.com/jtarchie/syslog/pkg/transports"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -24,11 +29,12 @@ func SendMessage(s *transports.UDPServer) {
 }
 
 var _ = Describe("Server", func() {
-	It("accepts datagrams via UDP", func() {
+	DescribeTable("accepts datagrams via UDP", func(options transports.Options) {
 		writer := &SpyWriter{}
-		server, err := transports.NewUDPServer(0, writer)
+		server, err := transports.NewUDPServer(0, writer, options)
 		Expect(err).ToNot(HaveOccurred())
 		go server.Start()
+		defer server.Close()
 
 		SendMessage(server)
 
@@ -37,9 +43,136 @@ var _ = Describe("Server", func() {
 		}).Should(Equal(1))
 
 		Expect(writer.Logs()[0].Message()).To(BeEquivalentTo(`'su root' failed for lonvick on /dev/pts/8`))
+	},
+		Entry("with defaults", transports.Options{}),
+		Entry("reading one datagram at a time", transports.Options{ReadBatchSize: 1}),
+		Entry("with a larger receive buffer", transports.Options{ReadBuffer: 1024 * 1024}),
+	)
+
+	DescribeTable("rejects datagrams larger than the maximum size", func(options transports.Options) {
+		if runtime.GOOS != "linux" {
+			Skip("truncation is only reported on linux")
+		}
+
+		registry := metrics.NewRegistry()
+		options.Metrics = registry
+		options.MaxDatagramSize = 1024
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, options)
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		conn, err := net.Dial("udp", server.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = fmt.Fprintf(conn, "<34>1 - host app - - - too long %s", strings.Repeat("a", 2048))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = fmt.Fprint(conn, "<34>1 - host app - - - fits")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(writer.Logs).Should(HaveLen(1))
+		Consistently(writer.Logs, "100ms").Should(HaveLen(1))
+		Expect(writer.Logs()[0].Message()).To(BeEquivalentTo("fits"))
+
+		exposition := &strings.Builder{}
+		Expect(registry.WriteTo(exposition)).Error().ToNot(HaveOccurred())
+		Expect(exposition.String()).To(And(
+			ContainSubstring(`syslog_datagrams_truncated_total{transport="udp"} 1`+"\n"),
+			ContainSubstring(`syslog_dropped_total{transport="udp",reason="truncated"} 1`+"\n"),
+		))
+	},
+		Entry("reading in batches", transports.Options{}),
+		Entry("reading one datagram at a time", transports.Options{ReadBatchSize: 1}),
+	)
+
+	It("shares the port across listeners with SO_REUSEPORT", func() {
+		if runtime.GOOS != "linux" {
+			Skip("SO_REUSEPORT is only supported on linux")
+		}
+
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{Listeners: 4})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		// each dial uses a new source port, spreading them over the sockets
+		for i := 0; i < 20; i++ {
+			SendMessage(server)
+		}
+
+		Eventually(func() int {
+			return len(writer.Logs())
+		}).Should(Equal(20))
+	})
+
+	It("delivers every datagram intact when flooded", func() {
+		writer := &SpyWriter{}
+		server, err := transports.NewUDPServer(0, writer, transports.Options{
+			MaxDatagramSize: 16 * 1024,
+			BatchInterval:   time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Start()
+		defer server.Close()
+
+		const senders, perSender = 4, 250
+
+		expected := map[string]bool{}
+		for i := 0; i < senders; i++ {
+			for j := 0; j < perSender; j++ {
+				expected[floodMessage(i, j)] = true
+			}
+		}
+
+		conns := make([]net.Conn, senders)
+		for i := range conns {
+			conns[i], err = net.Dial("udp", server.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conns[i].Close()
+		}
+
+		// senders write concurrently in bursts that fit in the socket's
+		// receive buffer, so loss can only come from the server itself
+		const burst = 5
+		for j := 0; j < perSender; j += burst {
+			wg := sync.WaitGroup{}
+			for i, conn := range conns {
+				wg.Add(1)
+				go func(sender int, conn net.Conn) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					for k := j; k < j+burst; k++ {
+						_, err := fmt.Fprintf(conn, "<34>1 2003-10-11T22:14:15.003Z sender-%d flood - - - %s", sender, floodMessage(sender, k))
+						Expect(err).ToNot(HaveOccurred())
+					}
+				}(i, conn)
+			}
+			wg.Wait()
+
+			Eventually(func() int {
+				return len(writer.Logs())
+			}).Should(Equal(senders * (j + burst)))
+		}
+
+		received := map[string]bool{}
+		for _, l := range writer.Logs() {
+			received[l.Message()] = true
+		}
+		Expect(received).To(Equal(expected))
 	})
 })
 
+// floodMessage returns a unique message whose length varies from a few
+// bytes to well past the old 1024 byte buffer.
+func floodMessage(sender, index int) string {
+	prefix := fmt.Sprintf("sender=%d index=%d ", sender, index)
+	return prefix + strings.Repeat(string(rune('a'+index%26)), (index*37)%3000)
+}
+
 type SpyWriter struct {
 	logs []*syslog.Log
 	mu   sync.Mutex
@@ -0,0 +1,82 @@
+package transports
+
+import (
+	"context"
+	"io"
+
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+type Writer interface {
+	Write(*syslog.Log) error
+}
+
+// BatchWriter is implemented by writers that can store many messages at
+// once. Transports group messages before calling WriteBatch, and call
+// Flush when they stop.
+type BatchWriter interface {
+	WriteBatch(context.Context, []*syslog.Log) error
+	Flush(context.Context) error
+	Close() error
+}
+
+// NewBatchWriter adapts a single message Writer into a BatchWriter. Writers
+// that are already a BatchWriter are returned as is.
+func NewBatchWriter(w Writer) BatchWriter {
+	if bw, ok := w.(BatchWriter); ok {
+		return bw
+	}
+	return &batchAdapter{writer: w}
+}
+
+type batchAdapter struct {
+	writer Writer
+}
+
+func (a *batchAdapter) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	envelopes := EnvelopesFromContext(ctx)
+
+	var err error
+	for i, l := range logs {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		var writeErr error
+		if i < len(envelopes) {
+			writeErr = write(a.writer, l, envelopes[i])
+		} else {
+			writeErr = a.writer.Write(l)
+		}
+		if writeErr != nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+func (a *batchAdapter) Flush(context.Context) error {
+	return nil
+}
+
+func (a *batchAdapter) Close() error {
+	if closer, ok := a.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type envelopesKey struct{}
+
+// WithEnvelopes attaches the envelopes of a batch to ctx, in the same order
+// as the logs passed to WriteBatch.
+func WithEnvelopes(ctx context.Context, envelopes []Envelope) context.Context {
+	return context.WithValue(ctx, envelopesKey{}, envelopes)
+}
+
+// EnvelopesFromContext returns the envelopes for the batch being written,
+// or nil if the transport didn't provide any.
+func EnvelopesFromContext(ctx context.Context) []Envelope {
+	envelopes, _ := ctx.Value(envelopesKey{}).([]Envelope)
+	return envelopes
+}
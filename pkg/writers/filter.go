@@ -0,0 +1,89 @@
+package writers
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+type FilterRule struct {
+	Match Match
+	// Sample is the fraction of matching logs kept, from 0, which drops
+	// them all, to 1. Sampling is evenly spread rather than random, so a
+	// rate of 0.1 keeps exactly every tenth log.
+	Sample float64
+}
+
+// Filter drops or samples logs before they reach its writer. The first
+// rule matching a log decides; logs matching no rule are kept.
+type Filter struct {
+	writer transports.BatchWriter
+	sink   transports.Writer
+	rules  []FilterRule
+
+	mu   sync.Mutex
+	seen []uint64
+}
+
+var _ transports.BatchWriter = &Filter{}
+
+func NewFilter(w transports.Writer, rules ...FilterRule) *Filter {
+	return &Filter{
+		writer: transports.NewBatchWriter(w),
+		sink:   w,
+		rules:  rules,
+		seen:   make([]uint64, len(rules)),
+	}
+}
+
+func (f *Filter) keep(l *syslog.Log) bool {
+	for i, rule := range f.rules {
+		if !rule.Match.Matches(l) {
+			continue
+		}
+		if rule.Sample <= 0 {
+			return false
+		}
+		if rule.Sample >= 1 {
+			return true
+		}
+
+		f.mu.Lock()
+		n := f.seen[i]
+		f.seen[i]++
+		f.mu.Unlock()
+
+		// keep the nth log when it moves the kept count up by one
+		return math.Floor(float64(n+1)*rule.Sample) > math.Floor(float64(n)*rule.Sample)
+	}
+	return true
+}
+
+func (f *Filter) Write(l *syslog.Log) error {
+	if !f.keep(l) {
+		return nil
+	}
+	return f.sink.Write(l)
+}
+
+func (f *Filter) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	envelopes := transports.EnvelopesFromContext(ctx)
+	var kept batch
+	for i, l := range logs {
+		if f.keep(l) {
+			kept.add(l, envelopes, i)
+		}
+	}
+	return kept.write(ctx, f.writer)
+}
+
+func (f *Filter) Flush(ctx context.Context) error {
+	return f.writer.Flush(ctx)
+}
+
+func (f *Filter) Close() error {
+	return f.writer.Close()
+}
@@ -0,0 +1,62 @@
+package writers_test
+
+import (
+	"context"
+	"fmt"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/writers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	debug := func(l *syslog.Log) { l.SetFacilitySeverity(1, 7) }
+
+	It("drops matching logs and keeps the rest", func() {
+		spy := &SpyWriter{}
+		filter := writers.NewFilter(spy, writers.FilterRule{
+			Match: writers.Match{Severities: []int{7}},
+		})
+
+		Expect(filter.Write(message("noise", debug))).To(Succeed())
+		Expect(filter.WriteBatch(context.Background(), []*syslog.Log{
+			message("request"),
+			message("more noise", debug),
+		})).To(Succeed())
+
+		Expect(spy.Messages()).To(Equal([]string{"request"}))
+	})
+
+	It("samples matching logs evenly", func() {
+		spy := &SpyWriter{}
+		filter := writers.NewFilter(spy, writers.FilterRule{
+			Match:  writers.Match{Appnames: []string{"nginx"}},
+			Sample: 0.25,
+		})
+
+		var logs []*syslog.Log
+		for i := 0; i < 100; i++ {
+			logs = append(logs, message(fmt.Sprintf("request %d", i)))
+		}
+		logs = append(logs, message("login", func(l *syslog.Log) { l.SetAppname("sshd") }))
+		Expect(filter.WriteBatch(context.Background(), logs)).To(Succeed())
+
+		Expect(spy.Messages()).To(HaveLen(26))
+		Expect(spy.Messages()).To(ContainElements("request 3", "request 7", "request 99", "login"))
+		Expect(spy.Messages()).ToNot(ContainElement("request 0"))
+	})
+
+	It("uses the first rule that matches", func() {
+		spy := &SpyWriter{}
+		filter := writers.NewFilter(spy,
+			writers.FilterRule{Match: writers.Match{Hostnames: []string{"web-1"}}, Sample: 1},
+			writers.FilterRule{Match: writers.Match{Severities: []int{7}}},
+		)
+
+		Expect(filter.Write(message("kept", debug))).To(Succeed())
+		Expect(filter.Write(message("dropped", debug, func(l *syslog.Log) { l.SetHostname("web-2") }))).To(Succeed())
+
+		Expect(spy.Messages()).To(Equal([]string{"kept"}))
+	})
+})
@@ -0,0 +1,105 @@
+package writers
+
+import (
+	"context"
+	"path"
+	"slices"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+// Param matches an SD-PARAM. Value is a glob and an empty Value matches
+// any value, so the param only has to be present.
+type Param struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// Match selects logs. Each set field must match, and a field with several
+// values matches if any of them does. Hostnames and Appnames are globs as
+// in path.Match. The zero Match selects everything.
+type Match struct {
+	Facilities []int    `json:"facilities,omitempty"`
+	Severities []int    `json:"severities,omitempty"`
+	Hostnames  []string `json:"hostnames,omitempty"`
+	Appnames   []string `json:"appnames,omitempty"`
+	Params     []Param  `json:"params,omitempty"`
+}
+
+func (m Match) Matches(l *syslog.Log) bool {
+	if len(m.Facilities) > 0 && !slices.Contains(m.Facilities, l.Facility()) {
+		return false
+	}
+	if len(m.Severities) > 0 && !slices.Contains(m.Severities, l.Severity()) {
+		return false
+	}
+	if len(m.Hostnames) > 0 && !globs(m.Hostnames, l.Hostname()) {
+		return false
+	}
+	if len(m.Appnames) > 0 && !globs(m.Appnames, l.Appname()) {
+		return false
+	}
+	for _, param := range m.Params {
+		if !hasParam(l, param) {
+			return false
+		}
+	}
+	return true
+}
+
+func globs(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasParam(l *syslog.Log, param Param) bool {
+	for _, element := range l.StructureData() {
+		if element.ID() != param.ID {
+			continue
+		}
+		for _, property := range element.Properties() {
+			if property.Key != param.Name {
+				continue
+			}
+			if param.Value == "" {
+				return true
+			}
+			if ok, _ := path.Match(param.Value, property.Value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// batch is the part of a batch going to one writer, along with the
+// envelopes of those logs when the transport provided them.
+type batch struct {
+	logs      []*syslog.Log
+	envelopes []transports.Envelope
+}
+
+func (b *batch) add(l *syslog.Log, envelopes []transports.Envelope, i int) {
+	b.logs = append(b.logs, l)
+	if i < len(envelopes) {
+		b.envelopes = append(b.envelopes, envelopes[i])
+	}
+}
+
+func (b *batch) write(ctx context.Context, w transports.BatchWriter) error {
+	if len(b.logs) == 0 {
+		return nil
+	}
+	if len(b.envelopes) == len(b.logs) {
+		ctx = transports.WithEnvelopes(ctx, b.envelopes)
+	} else {
+		ctx = transports.WithEnvelopes(ctx, nil)
+	}
+	return w.WriteBatch(ctx, b.logs)
+}
@@ -0,0 +1,75 @@
+package writers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+// Multi writes every log to all of its sinks. A sink that fails doesn't
+// stop the others from being written to; the failures are returned
+// together. Each sink but the last is given its own copy of the logs, so a
+// sink that changes them, such as a Redactor or Rewriter, doesn't change
+// what the others see.
+type Multi struct {
+	sinks   []transports.Writer
+	batches []transports.BatchWriter
+}
+
+var _ transports.BatchWriter = &Multi{}
+
+func NewMulti(sinks ...transports.Writer) *Multi {
+	batches := make([]transports.BatchWriter, len(sinks))
+	for i, sink := range sinks {
+		batches[i] = transports.NewBatchWriter(sink)
+	}
+	return &Multi{sinks: sinks, batches: batches}
+}
+
+func (m *Multi) Write(l *syslog.Log) error {
+	return m.each(func(i int) error {
+		if i < len(m.sinks)-1 {
+			return m.sinks[i].Write(l.Clone())
+		}
+		return m.sinks[i].Write(l)
+	})
+}
+
+func (m *Multi) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	return m.each(func(i int) error {
+		if i < len(m.sinks)-1 {
+			clones := make([]*syslog.Log, len(logs))
+			for j, l := range logs {
+				clones[j] = l.Clone()
+			}
+			return m.batches[i].WriteBatch(ctx, clones)
+		}
+		return m.batches[i].WriteBatch(ctx, logs)
+	})
+}
+
+func (m *Multi) Flush(ctx context.Context) error {
+	return m.each(func(i int) error {
+		return m.batches[i].Flush(ctx)
+	})
+}
+
+func (m *Multi) Close() error {
+	return m.each(func(i int) error {
+		return m.batches[i].Close()
+	})
+}
+
+func (m *Multi) each(fn func(int) error) error {
+	var errs []error
+	for i := range m.sinks {
+		err := fn(i)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sink %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+package writers_test
+
+import (
+	"context"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/writers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Multi", func() {
+	It("writes to every sink even when one fails", func() {
+		first, last := &SpyWriter{}, &SpyWriter{}
+		failing := &FailingWriter{}
+		multi := writers.NewMulti(first, failing, last)
+
+		err := multi.Write(message("one"))
+		Expect(err).To(MatchError("sink 1: disk full"))
+
+		err = multi.WriteBatch(context.Background(), []*syslog.Log{message("two"), message("three")})
+		Expect(err).To(MatchError("sink 1: disk full"))
+
+		Expect(first.Messages()).To(Equal([]string{"one", "two", "three"}))
+		Expect(last.Messages()).To(Equal([]string{"one", "two", "three"}))
+		Expect(failing.calls).To(Equal(3))
+	})
+
+	It("doesn't let a sink that changes logs change them for the others", func() {
+		redacted, plain, last := &SpyWriter{}, &SpyWriter{}, &SpyWriter{}
+		multi := writers.NewMulti(writers.NewRedactor(redacted, writers.RedactOptions{}), plain, last)
+
+		Expect(multi.Write(message("mail bob@example.com"))).To(Succeed())
+		Expect(multi.WriteBatch(context.Background(), []*syslog.Log{message("mail amy@example.com")})).To(Succeed())
+
+		Expect(redacted.Messages()).To(HaveLen(2))
+		Expect(redacted.Messages()).ToNot(ContainElement(ContainSubstring("@example.com")))
+		Expect(plain.Messages()).To(Equal([]string{"mail bob@example.com", "mail amy@example.com"}))
+		Expect(last.Messages()).To(Equal([]string{"mail bob@example.com", "mail amy@example.com"}))
+	})
+
+	It("flushes and closes every sink", func() {
+		first, second := &SpyWriter{}, &SpyWriter{}
+		multi := writers.NewMulti(first, second)
+
+		Expect(multi.Flush(context.Background())).To(Succeed())
+		Expect(multi.Close()).To(Succeed())
+		Expect(first.flushed).To(Equal(1))
+		Expect(second.closed).To(Equal(1))
+	})
+})
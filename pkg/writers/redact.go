@@ -0,0 +1,316 @@
+package writers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+// Detector finds one kind of sensitive value. When Pattern has a group,
+// only the first group is redacted, so "Bearer " can be kept in front of
+// a token. Valid, when set, rejects matches that only look sensitive.
+// Find, when set, is used instead of Pattern and Valid, for values a
+// pattern can't pick out, returning the start and end of each.
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Valid   func(string) bool
+	Find    func(string) [][2]int
+}
+
+var (
+	// DetectPAN finds card numbers of 13 to 19 digits, allowing spaces or
+	// dashes between them, that pass the Luhn check, including those run
+	// together with other numbers.
+	DetectPAN = Detector{
+		Name: "pan",
+		Find: findPANs,
+	}
+	// DetectJWT finds JSON web tokens.
+	DetectJWT = Detector{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	}
+	// DetectBearer finds the token in "Bearer <token>".
+	DetectBearer = Detector{
+		Name:    "bearer",
+		Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`),
+	}
+	DetectEmail = Detector{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	}
+	// DetectIP finds IPv4 and IPv6 addresses, including compressed ones
+	// such as ::1, leaving off any port.
+	DetectIP = Detector{
+		Name: "ip",
+		Find: findIPs,
+	}
+)
+
+// DefaultDetectors are used when RedactOptions has none.
+var DefaultDetectors = []Detector{DetectPAN, DetectJWT, DetectBearer, DetectEmail, DetectIP}
+
+// NewDetector builds a Detector from a custom pattern.
+func NewDetector(name, pattern string) (Detector, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return Detector{}, fmt.Errorf("detector %s: %w", name, err)
+	}
+	return Detector{Name: name, Pattern: compiled}, nil
+}
+
+func (d Detector) find(value string) [][2]int {
+	if d.Find != nil {
+		return d.Find(value)
+	}
+
+	var found [][2]int
+	for _, match := range d.Pattern.FindAllStringSubmatchIndex(value, -1) {
+		start, end := match[0], match[1]
+		if len(match) >= 4 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		if start == end {
+			continue
+		}
+		if d.Valid != nil && !d.Valid(value[start:end]) {
+			continue
+		}
+		found = append(found, [2]int{start, end})
+	}
+	return found
+}
+
+type RedactMode int
+
+const (
+	// RedactMask replaces values with "[REDACTED:<detector>]".
+	RedactMask RedactMode = iota
+	// RedactHash replaces values with "[<detector>:<hash>]", where the hash
+	// is the first 16 hex digits of an HMAC-SHA256 keyed by Key. Equal
+	// values get equal hashes, so they can still be searched for and
+	// correlated.
+	RedactHash
+)
+
+type RedactOptions struct {
+	// Detectors defaults to DefaultDetectors.
+	Detectors []Detector
+	Mode      RedactMode
+	// Key is the HMAC key for RedactHash. Without one, common values such
+	// as emails and IPs can be recovered by hashing guesses.
+	Key []byte
+}
+
+// Redactor removes sensitive values from messages and SD param values
+// before they reach its writer. Logs are changed in place, so one shared
+// with other writers should be behind a Multi, which gives each its own
+// copy, or come before them.
+type Redactor struct {
+	options RedactOptions
+	writer  transports.BatchWriter
+	sink    transports.Writer
+}
+
+var _ transports.BatchWriter = &Redactor{}
+
+func NewRedactor(w transports.Writer, options RedactOptions) *Redactor {
+	if len(options.Detectors) == 0 {
+		options.Detectors = DefaultDetectors
+	}
+	return &Redactor{
+		options: options,
+		writer:  transports.NewBatchWriter(w),
+		sink:    w,
+	}
+}
+
+// Redact redacts l in place.
+func (r *Redactor) Redact(l *syslog.Log) {
+	l.SetMessage(r.RedactString(l.Message()))
+	l.UpdateParams(func(_, _, value string) string {
+		return r.RedactString(value)
+	})
+}
+
+type span struct {
+	start, end int
+	detector   string
+}
+
+// RedactString replaces every value found by a detector. All detectors
+// look at the original value, and where their matches overlap the one
+// starting first, then the longest, then the earliest detector, wins.
+func (r *Redactor) RedactString(value string) string {
+	var spans []span
+	for _, detector := range r.options.Detectors {
+		for _, found := range detector.find(value) {
+			spans = append(spans, span{found[0], found[1], detector.Name})
+		}
+	}
+	if len(spans) == 0 {
+		return value
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var builder strings.Builder
+	position := 0
+	for _, s := range spans {
+		if s.start < position {
+			continue
+		}
+		builder.WriteString(value[position:s.start])
+		builder.WriteString(r.replacement(s.detector, value[s.start:s.end]))
+		position = s.end
+	}
+	builder.WriteString(value[position:])
+	return builder.String()
+}
+
+func (r *Redactor) replacement(detector, value string) string {
+	if r.options.Mode == RedactHash {
+		mac := hmac.New(sha256.New, r.options.Key)
+		mac.Write([]byte(value))
+		return "[" + detector + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+	}
+	return "[REDACTED:" + detector + "]"
+}
+
+func (r *Redactor) Write(l *syslog.Log) error {
+	r.Redact(l)
+	return r.sink.Write(l)
+}
+
+func (r *Redactor) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	for _, l := range logs {
+		r.Redact(l)
+	}
+	return r.writer.WriteBatch(ctx, logs)
+}
+
+func (r *Redactor) Flush(ctx context.Context) error {
+	return r.writer.Flush(ctx)
+}
+
+func (r *Redactor) Close() error {
+	return r.writer.Close()
+}
+
+var digitRun = regexp.MustCompile(`\b\d(?:[ -]?\d)*\b`)
+
+// findPANs checks every window of 13 to 19 digits in each run of digits
+// that starts and ends where the run's groups do, so a card number next to
+// a CVV or an order number is still found, taking the leftmost and then
+// longest that passes. Windows splitting a group aren't checked, as one in
+// ten of them would pass by chance.
+func findPANs(value string) [][2]int {
+	var found [][2]int
+	for _, run := range digitRun.FindAllStringIndex(value, -1) {
+		var digits []int
+		for i := run[0]; i < run[1]; i++ {
+			if value[i] != ' ' && value[i] != '-' {
+				digits = append(digits, i)
+			}
+		}
+		starts := func(i int) bool { return i == 0 || digits[i]-digits[i-1] > 1 }
+		ends := func(j int) bool { return j == len(digits)-1 || digits[j+1]-digits[j] > 1 }
+
+		for i := 0; i+13 <= len(digits); i++ {
+			if !starts(i) {
+				continue
+			}
+			for j := min(i+19, len(digits)) - 1; j >= i+12; j-- {
+				if ends(j) && luhn(value[digits[i]:digits[j]+1]) {
+					found = append(found, [2]int{digits[i], digits[j] + 1})
+					i = j
+					break
+				}
+			}
+		}
+	}
+	return found
+}
+
+// findIPs parses each token of hex digits, dots and colons that stands on
+// its own, so a compressed IPv6 address starting with a colon is found,
+// and a port after an address is left out.
+func findIPs(value string) [][2]int {
+	var found [][2]int
+	for start := 0; start < len(value); {
+		if !ipChar(value[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(value) && ipChar(value[end]) {
+			end++
+		}
+		if (start == 0 || !wordChar(value[start-1])) && (end == len(value) || !wordChar(value[end])) {
+			if length, ok := ipLength(value[start:end]); ok {
+				found = append(found, [2]int{start, start + length})
+			}
+		}
+		start = end
+	}
+	return found
+}
+
+// ipLength is the length of the address a token is, or starts with when
+// it is followed by a port or punctuation.
+func ipLength(token string) (int, bool) {
+	for _, candidate := range []string{token, strings.TrimRight(token, ".:")} {
+		if addr, err := netip.ParseAddr(candidate); err == nil && addr.IsValid() {
+			return len(candidate), true
+		}
+		if addrPort, err := netip.ParseAddrPort(candidate); err == nil && addrPort.Addr().Is4() {
+			return strings.LastIndexByte(candidate, ':'), true
+		}
+	}
+	return 0, false
+}
+
+func ipChar(c byte) bool {
+	return c == '.' || c == ':' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func wordChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// luhn reports whether the digits in value, ignoring spaces and dashes,
+// pass the Luhn check.
+func luhn(value string) bool {
+	sum, digits := 0, 0
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		digit := int(c - '0')
+		if digits%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
@@ -0,0 +1,355 @@
+// Package relay stores logs on disk and forwards them to a collector,
+// holding on to them for as long as the collector is unreachable.
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/metrics"
+	bolt "go.etcd.io/bbolt"
+)
+
+var queueBucket = []byte("queue")
+
+const (
+	defaultBatchSize    = 100
+	defaultMinBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff   = time.Minute
+	defaultWriteTimeout = 30 * time.Second
+)
+
+type Options struct {
+	// Client configures the connection to the collector. MaxAttempts
+	// defaults to 1, as the relay retries on its own. WriteTimeout defaults
+	// to 30s, so a collector that stops acknowledging can't hold up Close.
+	Client client.Options
+	// BatchSize is how many messages are read from, and removed from, the
+	// queue at a time. Defaults to 100.
+	BatchSize int
+	// MinBackoff and MaxBackoff bound the wait between forwarding attempts
+	// while the collector is failing, which doubles after each failure.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Metrics    *metrics.Registry
+	// Unacknowledged allows forwarding over transports other than RELP,
+	// which can lose the messages the collector had yet to read when it
+	// disconnects.
+	Unacknowledged bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Client.MaxAttempts <= 0 {
+		o.Client.MaxAttempts = 1
+	}
+	if o.Client.WriteTimeout <= 0 {
+		o.Client.WriteTimeout = defaultWriteTimeout
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = max(defaultMaxBackoff, o.MinBackoff)
+	}
+	if o.Metrics == nil {
+		o.Metrics = metrics.NewRegistry()
+	}
+	return o
+}
+
+// Relay is a writer that appends logs to a bolt queue and forwards them in
+// order from a background goroutine. A message is removed from the queue
+// only once the collector has acknowledged it over RELP, so the queue
+// survives restarts and disconnects. A crash between sending a batch and
+// removing it can resend that batch; a clean Close cannot. With
+// Unacknowledged, a message is removed once written to the connection.
+type Relay struct {
+	db      *bolt.DB
+	client  *client.Client
+	options Options
+
+	size      atomic.Int64
+	forwarded *metrics.Counter
+	errors    *metrics.Counter
+
+	wake     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	closing  sync.Once
+	closeErr error
+}
+
+// New opens, or creates, the queue at path and starts forwarding to uri,
+// e.g. "relp://collector:2514". Any scheme client.New takes is allowed with
+// Unacknowledged, otherwise only relp and relp+tls.
+func New(path, uri string, options Options) (*Relay, error) {
+	options = options.withDefaults()
+
+	c, err := client.New(uri, options.Client)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Acknowledged() && !options.Unacknowledged {
+		return nil, fmt.Errorf("forwarding to %q can lose messages, as only relp and relp+tls are acknowledged", uri)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open queue: %w", err)
+	}
+
+	r := &Relay{
+		db:      db,
+		client:  c,
+		options: options,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(queueBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		r.size.Store(int64(bucket.Stats().KeyN))
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	r.forwarded = options.Metrics.Counter(
+		"syslog_relay_forwarded_total",
+		"Messages forwarded to the collector.",
+	).With()
+	r.errors = options.Metrics.Counter(
+		"syslog_relay_forward_errors_total",
+		"Failed attempts to forward to the collector.",
+	).With()
+	options.Metrics.Gauge(
+		"syslog_relay_queue_size",
+		"Messages waiting to be forwarded.",
+	).With().SetFunc(func() float64 {
+		return float64(r.Size())
+	})
+	options.Metrics.Gauge(
+		"syslog_relay_oldest_message_age_seconds",
+		"Time the oldest queued message has been waiting.",
+	).With().SetFunc(func() float64 {
+		return r.OldestAge().Seconds()
+	})
+
+	go r.forward()
+	r.notify()
+
+	return r, nil
+}
+
+func (r *Relay) Write(l *syslog.Log) error {
+	return r.WriteBatch(context.Background(), []*syslog.Log{l})
+}
+
+// WriteBatch appends the logs to the queue in a single transaction.
+func (r *Relay) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(queueBucket)
+		for _, l := range logs {
+			sequence, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(key(sequence), encode(now, l.String()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.size.Add(int64(len(logs)))
+	r.notify()
+	return nil
+}
+
+// Flush is a no-op, as WriteBatch has already committed the logs to disk.
+func (r *Relay) Flush(context.Context) error {
+	return nil
+}
+
+// Close stops forwarding, waiting for an in-flight batch, and closes the
+// queue. Anything not yet forwarded is forwarded after the next New.
+func (r *Relay) Close() error {
+	r.closing.Do(func() {
+		close(r.done)
+		<-r.stopped
+
+		r.closeErr = errors.Join(r.client.Close(), r.db.Close())
+	})
+	return r.closeErr
+}
+
+func (r *Relay) Metrics() *metrics.Registry {
+	return r.options.Metrics
+}
+
+// Size is the number of messages waiting to be forwarded.
+func (r *Relay) Size() int {
+	return int(r.size.Load())
+}
+
+// OldestAge is how long the oldest queued message has been waiting, zero
+// when the queue is empty.
+func (r *Relay) OldestAge() time.Duration {
+	var age time.Duration
+	_ = r.db.View(func(tx *bolt.Tx) error {
+		_, value := tx.Bucket(queueBucket).Cursor().First()
+		if value != nil {
+			enqueuedAt, _ := decode(value)
+			age = time.Since(enqueuedAt)
+		}
+		return nil
+	})
+	return age
+}
+
+func (r *Relay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) forward() {
+	defer close(r.stopped)
+
+	var backoff time.Duration
+	for {
+		sent, err := r.forwardBatch()
+		if err != nil {
+			r.errors.Inc()
+			log.Printf("relay: could not forward: %s", err)
+
+			if backoff == 0 {
+				backoff = r.options.MinBackoff
+			} else {
+				backoff = min(2*backoff, r.options.MaxBackoff)
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-r.done:
+				return
+			}
+			continue
+		}
+		backoff = 0
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		if sent == 0 {
+			select {
+			case <-r.wake:
+			case <-r.done:
+				return
+			}
+		}
+	}
+}
+
+// forwardBatch sends up to BatchSize of the oldest messages and removes
+// the ones that were sent.
+func (r *Relay) forwardBatch() (int, error) {
+	var (
+		keys     [][]byte
+		messages [][]byte
+	)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(queueBucket).Cursor()
+		for k, v := cursor.First(); k != nil && len(keys) < r.options.BatchSize; k, v = cursor.Next() {
+			_, message := decode(v)
+			keys = append(keys, append([]byte{}, k...))
+			messages = append(messages, append([]byte{}, message...))
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	sent := 0
+	var sendErr error
+	for _, message := range messages {
+		_, sendErr = r.client.Write(message)
+		if sendErr != nil {
+			break
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		err = r.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(queueBucket)
+			for _, k := range keys[:sent] {
+				err := bucket.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not remove forwarded messages: %w", err)
+		}
+
+		r.size.Add(-int64(sent))
+		r.forwarded.Add(float64(sent))
+	}
+
+	return sent, sendErr
+}
+
+func key(sequence uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, sequence)
+	return k
+}
+
+// encode prefixes the message with the time it was queued.
+func encode(enqueuedAt time.Time, message string) []byte {
+	value := make([]byte, 8, 8+len(message))
+	binary.BigEndian.PutUint64(value, uint64(enqueuedAt.UnixNano()))
+	return append(value, message...)
+}
+
+func decode(value []byte) (time.Time, []byte) {
+	if len(value) < 8 {
+		return time.Time{}, value
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), value[8:]
+}
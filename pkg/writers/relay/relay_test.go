@@ -0,0 +1,342 @@
+package relay_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/client"
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/writers/relay"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// collector accepts line framed messages over TCP.
+type collector struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received []string
+}
+
+func startCollector(address string) *collector {
+	listener, err := net.Listen("tcp", address)
+	Expect(err).ToNot(HaveOccurred())
+
+	c := &collector{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					c.mu.Lock()
+					c.received = append(c.received, scanner.Text())
+					c.mu.Unlock()
+				}
+			}()
+		}
+	}()
+	DeferCleanup(c.Close)
+	return c
+}
+
+func (c *collector) Close() {
+	c.listener.Close()
+}
+
+func (c *collector) Received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.received...)
+}
+
+// relpCollector acknowledges RELP syslog commands, recording only those it
+// acknowledged. With hangUpAfter positive, it disconnects from each session
+// after acknowledging that many, without acknowledging the next.
+type relpCollector struct {
+	listener    net.Listener
+	hangUpAfter int
+
+	mu           sync.Mutex
+	acknowledged []string
+	hungUp       int
+}
+
+func startRELPCollector(hangUpAfter int) *relpCollector {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	c := &relpCollector{listener: listener, hangUpAfter: hangUpAfter}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go c.handle(conn)
+		}
+	}()
+	return c
+}
+
+func (c *relpCollector) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	handled := 0
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		// "TXNR COMMAND DATALEN [DATA]", where DATA can hold newlines
+		fields := strings.SplitN(strings.TrimSuffix(header, "\n"), " ", 4)
+		if len(fields) < 3 {
+			return
+		}
+		txnr, command := fields[0], fields[1]
+		length, _ := strconv.Atoi(fields[2])
+		data := ""
+		if len(fields) == 4 {
+			data = fields[3]
+		}
+		for len(data) < length {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			data += "\n" + strings.TrimSuffix(line, "\n")
+		}
+
+		switch command {
+		case "open":
+			fmt.Fprintf(conn, "%s rsp %d 200 OK\n%s\n", txnr, len("200 OK\n")+len(data), data)
+		case "syslog":
+			if c.hangUpAfter > 0 && handled == c.hangUpAfter {
+				c.mu.Lock()
+				c.hungUp++
+				c.mu.Unlock()
+				return
+			}
+			handled++
+			c.mu.Lock()
+			c.acknowledged = append(c.acknowledged, data)
+			c.mu.Unlock()
+			fmt.Fprintf(conn, "%s rsp 6 200 OK\n", txnr)
+		case "close":
+			fmt.Fprintf(conn, "%s rsp 0\n", txnr)
+			return
+		}
+	}
+}
+
+func (c *relpCollector) Acknowledged() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.acknowledged...)
+}
+
+func (c *relpCollector) HungUp() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hungUp
+}
+
+// unusedAddress returns an address nothing is listening on.
+func unusedAddress() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func message(index int) *syslog.Log {
+	log := syslog.New()
+	log.SetFacilitySeverity(1, 6)
+	log.SetHostname("edge")
+	log.SetMessage(fmt.Sprintf("message %d", index))
+	return log
+}
+
+func expected(from, to int) []string {
+	var messages []string
+	for i := from; i < to; i++ {
+		messages = append(messages, message(i).String())
+	}
+	return messages
+}
+
+var _ = Describe("Relay", func() {
+	var (
+		path    string
+		options relay.Options
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "queue.db")
+		options = relay.Options{
+			Client:     client.Options{Framing: client.NonTransparent},
+			BatchSize:  7,
+			MinBackoff: 10 * time.Millisecond,
+			MaxBackoff: 50 * time.Millisecond,
+			// the line framed collector can't acknowledge messages
+			Unacknowledged: true,
+		}
+	})
+
+	It("only forwards over transports that acknowledge messages unless allowed", func() {
+		options.Unacknowledged = false
+
+		_, err := relay.New(path, "tcp://"+unusedAddress(), options)
+		Expect(err).To(MatchError(ContainSubstring("only relp and relp+tls are acknowledged")))
+
+		writer, err := relay.New(path, "relp://"+unusedAddress(), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Close()).To(Succeed())
+	})
+
+	It("keeps messages the collector disconnected before acknowledging", func() {
+		options.Unacknowledged = false
+		collector := startRELPCollector(5)
+
+		writer, err := relay.New(path, "relp://"+collector.listener.Addr().String(), options)
+		Expect(err).ToNot(HaveOccurred())
+		defer writer.Close()
+
+		for i := 0; i < 20; i++ {
+			Expect(writer.Write(message(i))).To(Succeed())
+		}
+
+		Eventually(collector.Acknowledged).Should(Equal(expected(0, 20)))
+		Eventually(writer.Size).Should(BeZero())
+		Expect(collector.HungUp()).To(BeNumerically(">=", 3))
+	})
+
+	It("closes while the collector never acknowledges", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(listener.Close)
+
+		accepted := make(chan net.Conn, 10)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+		DeferCleanup(func() {
+			for len(accepted) > 0 {
+				(<-accepted).Close()
+			}
+		})
+
+		options.Unacknowledged = false
+		options.Client.WriteTimeout = 100 * time.Millisecond
+		writer, err := relay.New(path, "relp://"+listener.Addr().String(), options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Write(message(0))).To(Succeed())
+		Eventually(func() int { return len(accepted) }).ShouldNot(BeZero())
+
+		closed := make(chan error, 1)
+		go func() { closed <- writer.Close() }()
+		Eventually(closed).Should(Receive())
+		Expect(writer.Size()).To(Equal(1))
+	})
+
+	It("can be closed more than once", func() {
+		writer, err := relay.New(path, "tcp://"+unusedAddress(), options)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+	})
+
+	It("forwards messages in order", func() {
+		collector := startCollector("127.0.0.1:0")
+
+		writer, err := relay.New(path, "tcp://"+collector.listener.Addr().String(), options)
+		Expect(err).ToNot(HaveOccurred())
+		defer writer.Close()
+
+		for i := 0; i < 20; i++ {
+			Expect(writer.Write(message(i))).To(Succeed())
+		}
+
+		Eventually(collector.Received).Should(Equal(expected(0, 20)))
+		Eventually(writer.Size).Should(BeZero())
+		Expect(writer.OldestAge()).To(BeZero())
+	})
+
+	It("holds messages while the collector is down", func() {
+		address := unusedAddress()
+
+		writer, err := relay.New(path, "tcp://"+address, options)
+		Expect(err).ToNot(HaveOccurred())
+		defer writer.Close()
+
+		for i := 0; i < 10; i++ {
+			Expect(writer.Write(message(i))).To(Succeed())
+		}
+
+		Consistently(writer.Size, 100*time.Millisecond).Should(Equal(10))
+		Expect(writer.OldestAge()).To(BeNumerically(">=", 100*time.Millisecond))
+
+		var metrics strings.Builder
+		writer.Metrics().WriteTo(&metrics)
+		Expect(metrics.String()).To(ContainSubstring("syslog_relay_queue_size 10\n"))
+		Expect(metrics.String()).To(MatchRegexp(`syslog_relay_forward_errors_total [1-9]`))
+
+		collector := startCollector(address)
+		Eventually(collector.Received).Should(Equal(expected(0, 10)))
+		Eventually(writer.Size).Should(BeZero())
+	})
+
+	It("survives restarts without losing or duplicating messages", func() {
+		address := unusedAddress()
+
+		writer, err := relay.New(path, "tcp://"+address, options)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 15; i++ {
+			Expect(writer.Write(message(i))).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+
+		collector := startCollector(address)
+
+		writer, err = relay.New(path, "tcp://"+address, options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Size()).To(Equal(15))
+		for i := 15; i < 20; i++ {
+			Expect(writer.Write(message(i))).To(Succeed())
+		}
+		Eventually(collector.Received).Should(Equal(expected(0, 20)))
+		Eventually(writer.Size).Should(BeZero())
+		Expect(writer.Close()).To(Succeed())
+
+		writer, err = relay.New(path, "tcp://"+address, options)
+		Expect(err).ToNot(HaveOccurred())
+		defer writer.Close()
+
+		Expect(writer.Size()).To(BeZero())
+		Consistently(collector.Received, 100*time.Millisecond).Should(HaveLen(20))
+	})
+})
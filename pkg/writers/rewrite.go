@@ -0,0 +1,396 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+type Action string
+
+const (
+	// ActionSet sets Field to Value.
+	ActionSet Action = "set"
+	// ActionDelete empties Field, or removes it when it is structured data.
+	// A field of "sd.<id>" removes the whole element.
+	ActionDelete Action = "delete"
+	// ActionRename moves Field to To, removing Field.
+	ActionRename Action = "rename"
+	// ActionReplace replaces matches of Pattern in Field, the message by
+	// default, with Replacement, which can refer to groups as $1 or ${name}.
+	ActionReplace Action = "replace"
+	// ActionLower lowercases Field.
+	ActionLower Action = "lower"
+	// ActionAdd sets Params in the element ID, adding it when missing.
+	ActionAdd Action = "add"
+	// ActionExtract matches Pattern against Field, the message by default,
+	// and sets each named group as a param in the element ID.
+	ActionExtract Action = "extract"
+	// ActionDrop stops processing and drops the log.
+	ActionDrop Action = "drop"
+)
+
+// Rule is applied to logs its Match selects. Fields are hostname, appname,
+// procid, msgid, message, facility, severity, or a structured data param
+// as "sd.<id>.<param>". Values, ids and param names must be valid for
+// RFC 5424, as checked by the syslog Validate functions; what a replace or
+// lower makes of a field is made valid by the log's setters.
+type Rule struct {
+	Match       Match             `json:"match"`
+	Action      Action            `json:"action"`
+	Field       string            `json:"field,omitempty"`
+	Value       string            `json:"value,omitempty"`
+	To          string            `json:"to,omitempty"`
+	Pattern     string            `json:"pattern,omitempty"`
+	Replacement string            `json:"replacement,omitempty"`
+	ID          string            `json:"id,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+type rule struct {
+	Rule
+	field   field
+	to      field
+	pattern *regexp.Regexp
+	params  []syslog.Property
+}
+
+// Chain applies rules in order, changing logs in place.
+type Chain struct {
+	rules []rule
+}
+
+// NewChain validates the rules, compiling their patterns.
+func NewChain(rules ...Rule) (*Chain, error) {
+	chain := &Chain{}
+	for i, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		chain.rules = append(chain.rules, compiled)
+	}
+	return chain, nil
+}
+
+// LoadChain reads a JSON array of rules from path.
+func LoadChain(path string) (*Chain, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rules: %w", err)
+	}
+
+	var rules []Rule
+	err = json.Unmarshal(contents, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse rules in %s: %w", path, err)
+	}
+	return NewChain(rules...)
+}
+
+func compile(r Rule) (rule, error) {
+	compiled := rule{Rule: r}
+
+	var err error
+	needsField := func(field string) (field, error) {
+		if field == "" {
+			return nil, fmt.Errorf("%s needs a field", r.Action)
+		}
+		return parseField(field)
+	}
+
+	switch r.Action {
+	case ActionSet:
+		compiled.field, err = needsField(r.Field)
+		if err == nil {
+			err = compiled.field.validate(r.Value)
+		}
+	case ActionDelete, ActionLower:
+		compiled.field, err = needsField(r.Field)
+	case ActionRename:
+		compiled.field, err = needsField(r.Field)
+		if err == nil {
+			compiled.to, err = needsField(r.To)
+		}
+		if target, ok := compiled.to.(sd); ok && err == nil {
+			err = target.validate("")
+		}
+	case ActionReplace, ActionExtract:
+		if r.Field == "" {
+			r.Field = "message"
+		}
+		compiled.field, err = parseField(r.Field)
+		if err != nil {
+			break
+		}
+		compiled.pattern, err = regexp.Compile(r.Pattern)
+		if err != nil {
+			break
+		}
+		if r.Action != ActionExtract {
+			break
+		}
+		if r.ID == "" {
+			err = fmt.Errorf("extract needs an id")
+			break
+		}
+		err = syslog.ValidateSDName(r.ID)
+		for _, name := range compiled.pattern.SubexpNames() {
+			if name != "" && err == nil {
+				err = syslog.ValidateSDName(name)
+			}
+		}
+	case ActionAdd:
+		if r.ID == "" {
+			err = fmt.Errorf("add needs an id")
+			break
+		}
+		err = syslog.ValidateSDName(r.ID)
+		names := make([]string, 0, len(r.Params))
+		for name := range r.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err == nil {
+				err = syslog.ValidateSDName(name)
+			}
+			compiled.params = append(compiled.params, syslog.Property{Key: name, Value: r.Params[name]})
+		}
+	case ActionDrop:
+	default:
+		err = fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	return compiled, err
+}
+
+// Process applies the rules to l, returning false when a rule drops it.
+func (c *Chain) Process(l *syslog.Log) bool {
+	for _, r := range c.rules {
+		if !r.Match.Matches(l) {
+			continue
+		}
+
+		switch r.Action {
+		case ActionSet:
+			r.field.set(l, r.Value)
+		case ActionDelete:
+			r.field.delete(l)
+		case ActionRename:
+			if value, ok := r.field.get(l); ok && r.to.validate(value) == nil {
+				r.field.delete(l)
+				r.to.set(l, value)
+			}
+		case ActionReplace:
+			if value, ok := r.field.get(l); ok {
+				r.field.set(l, r.pattern.ReplaceAllString(value, r.Replacement))
+			}
+		case ActionLower:
+			if value, ok := r.field.get(l); ok {
+				r.field.set(l, strings.ToLower(value))
+			}
+		case ActionAdd:
+			for _, param := range r.params {
+				l.SetParam(r.ID, param.Key, param.Value)
+			}
+		case ActionExtract:
+			value, _ := r.field.get(l)
+			matches := r.pattern.FindStringSubmatch(value)
+			for i, name := range r.pattern.SubexpNames() {
+				if i > 0 && name != "" && i < len(matches) {
+					l.SetParam(r.ID, name, matches[i])
+				}
+			}
+		case ActionDrop:
+			return false
+		}
+	}
+	return true
+}
+
+// Rewriter runs logs through a Chain before writing them, dropping those
+// the chain drops. Logs are changed in place, so one shared with other
+// writers should be behind a Multi, which gives each its own copy, or come
+// before them.
+type Rewriter struct {
+	chain  *Chain
+	writer transports.BatchWriter
+	sink   transports.Writer
+}
+
+var _ transports.BatchWriter = &Rewriter{}
+
+func NewRewriter(w transports.Writer, chain *Chain) *Rewriter {
+	return &Rewriter{
+		chain:  chain,
+		writer: transports.NewBatchWriter(w),
+		sink:   w,
+	}
+}
+
+func (r *Rewriter) Write(l *syslog.Log) error {
+	if !r.chain.Process(l) {
+		return nil
+	}
+	return r.sink.Write(l)
+}
+
+func (r *Rewriter) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	envelopes := transports.EnvelopesFromContext(ctx)
+	var kept batch
+	for i, l := range logs {
+		if r.chain.Process(l) {
+			kept.add(l, envelopes, i)
+		}
+	}
+	return kept.write(ctx, r.writer)
+}
+
+func (r *Rewriter) Flush(ctx context.Context) error {
+	return r.writer.Flush(ctx)
+}
+
+func (r *Rewriter) Close() error {
+	return r.writer.Close()
+}
+
+// field reads and writes one part of a log.
+type field interface {
+	get(*syslog.Log) (string, bool)
+	set(*syslog.Log, string)
+	delete(*syslog.Log)
+	// validate reports whether set accepts the value.
+	validate(string) error
+}
+
+func parseField(name string) (field, error) {
+	switch name {
+	case "hostname":
+		return header{(*syslog.Log).Hostname, (*syslog.Log).SetHostname, syslog.ValidateHostname}, nil
+	case "appname":
+		return header{(*syslog.Log).Appname, (*syslog.Log).SetAppname, syslog.ValidateAppname}, nil
+	case "procid":
+		return header{(*syslog.Log).ProcID, (*syslog.Log).SetProcID, syslog.ValidateProcID}, nil
+	case "msgid":
+		return header{(*syslog.Log).MsgID, (*syslog.Log).SetMsgID, syslog.ValidateMsgID}, nil
+	case "message":
+		return header{(*syslog.Log).Message, (*syslog.Log).SetMessage, nil}, nil
+	case "facility":
+		return priority{facility: true, max: 23}, nil
+	case "severity":
+		return priority{max: 7}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(name, "sd."); ok {
+		id, param, _ := strings.Cut(rest, ".")
+		if id != "" {
+			err := syslog.ValidateSDName(id)
+			if err == nil && param != "" {
+				err = syslog.ValidateSDName(param)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", name, err)
+			}
+			return sd{id: id, param: param}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown field %q", name)
+}
+
+type header struct {
+	getter func(*syslog.Log) string
+	setter func(*syslog.Log, string)
+	// validator is nil for the message, which can be anything
+	validator func(string) error
+}
+
+func (h header) get(l *syslog.Log) (string, bool) {
+	value := h.getter(l)
+	return value, value != ""
+}
+
+func (h header) set(l *syslog.Log, value string) { h.setter(l, value) }
+func (h header) delete(l *syslog.Log)            { h.setter(l, "") }
+
+func (h header) validate(value string) error {
+	if h.validator == nil {
+		return nil
+	}
+	return h.validator(value)
+}
+
+type priority struct {
+	facility bool
+	max      int
+}
+
+func (p priority) get(l *syslog.Log) (string, bool) {
+	if p.facility {
+		return strconv.Itoa(l.Facility()), true
+	}
+	return strconv.Itoa(l.Severity()), true
+}
+
+func (p priority) set(l *syslog.Log, value string) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	if p.facility {
+		l.SetFacilitySeverity(number, l.Severity())
+	} else {
+		l.SetFacilitySeverity(l.Facility(), number)
+	}
+}
+
+func (p priority) delete(l *syslog.Log) { p.set(l, "0") }
+
+func (p priority) validate(value string) error {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 || number > p.max {
+		return fmt.Errorf("%q is not between 0 and %d", value, p.max)
+	}
+	return nil
+}
+
+type sd struct {
+	id    string
+	param string
+}
+
+func (s sd) get(l *syslog.Log) (string, bool) {
+	if s.param == "" {
+		return "", false
+	}
+	return l.Param(s.id, s.param)
+}
+
+func (s sd) set(l *syslog.Log, value string) {
+	if s.param != "" {
+		l.SetParam(s.id, s.param, value)
+	}
+}
+
+func (s sd) delete(l *syslog.Log) {
+	if s.param == "" {
+		l.DeleteStructureElement(s.id)
+		return
+	}
+	l.DeleteParam(s.id, s.param)
+}
+
+func (s sd) validate(string) error {
+	if s.param == "" {
+		return fmt.Errorf("sd.%s needs a param name", s.id)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package writers_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/writers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rewrite", func() {
+	original := func() *syslog.Log {
+		return message("user=bob password=hunter2 logged in", func(l *syslog.Log) {
+			l.SetHostname("WEB-1.Example.com")
+			l.SetProcID("123")
+			l.AddStructureElement("meta", syslog.Property{Key: "region", Value: "us-east"})
+		})
+	}
+
+	DescribeTable("rules", func(rule writers.Rule, expected string) {
+		chain, err := writers.NewChain(rule)
+		Expect(err).ToNot(HaveOccurred())
+
+		l := original()
+		Expect(chain.Process(l)).To(BeTrue())
+		Expect(l.String()).To(Equal(expected))
+	},
+		Entry("set a header",
+			writers.Rule{Action: writers.ActionSet, Field: "appname", Value: "proxy"},
+			`<14>1 - WEB-1.Example.com proxy 123 - [meta region="us-east"] user=bob password=hunter2 logged in`),
+		Entry("set the severity",
+			writers.Rule{Action: writers.ActionSet, Field: "severity", Value: "3"},
+			`<11>1 - WEB-1.Example.com nginx 123 - [meta region="us-east"] user=bob password=hunter2 logged in`),
+		Entry("set a param",
+			writers.Rule{Action: writers.ActionSet, Field: "sd.meta.zone", Value: "a"},
+			`<14>1 - WEB-1.Example.com nginx 123 - [meta region="us-east" zone="a"] user=bob password=hunter2 logged in`),
+		Entry("delete a header",
+			writers.Rule{Action: writers.ActionDelete, Field: "procid"},
+			`<14>1 - WEB-1.Example.com nginx - - [meta region="us-east"] user=bob password=hunter2 logged in`),
+		Entry("delete an element",
+			writers.Rule{Action: writers.ActionDelete, Field: "sd.meta"},
+			`<14>1 - WEB-1.Example.com nginx 123 - - user=bob password=hunter2 logged in`),
+		Entry("rename a param to a header",
+			writers.Rule{Action: writers.ActionRename, Field: "sd.meta.region", To: "msgid"},
+			`<14>1 - WEB-1.Example.com nginx 123 us-east [meta] user=bob password=hunter2 logged in`),
+		Entry("rename a header to a param",
+			writers.Rule{Action: writers.ActionRename, Field: "procid", To: "sd.origin.pid"},
+			`<14>1 - WEB-1.Example.com nginx - - [meta region="us-east"][origin pid="123"] user=bob password=hunter2 logged in`),
+		Entry("replace in the message",
+			writers.Rule{Action: writers.ActionReplace, Pattern: `password=\S+`, Replacement: "password=***"},
+			`<14>1 - WEB-1.Example.com nginx 123 - [meta region="us-east"] user=bob password=*** logged in`),
+		Entry("replace with groups in a header",
+			writers.Rule{Action: writers.ActionReplace, Field: "hostname", Pattern: `^([^.]+)\..*$`, Replacement: "$1"},
+			`<14>1 - WEB-1 nginx 123 - [meta region="us-east"] user=bob password=hunter2 logged in`),
+		Entry("lowercase the hostname",
+			writers.Rule{Action: writers.ActionLower, Field: "hostname"},
+			`<14>1 - web-1.example.com nginx 123 - [meta region="us-east"] user=bob password=hunter2 logged in`),
+		Entry("add structured data",
+			writers.Rule{Action: writers.ActionAdd, ID: "enrich", Params: map[string]string{"site": "dc1", "env": "prod"}},
+			`<14>1 - WEB-1.Example.com nginx 123 - [meta region="us-east"][enrich env="prod" site="dc1"] user=bob password=hunter2 logged in`),
+		Entry("extract captures",
+			writers.Rule{Action: writers.ActionExtract, Pattern: `user=(?P<user>\w+)`, ID: "enrich"},
+			`<14>1 - WEB-1.Example.com nginx 123 - [meta region="us-east"][enrich user="bob"] user=bob password=hunter2 logged in`),
+		Entry("skip logs the match doesn't select",
+			writers.Rule{Match: writers.Match{Appnames: []string{"sshd"}}, Action: writers.ActionDelete, Field: "message"},
+			`<14>1 - WEB-1.Example.com nginx 123 - [meta region="us-east"] user=bob password=hunter2 logged in`),
+	)
+
+	DescribeTable("invalid rules", func(rule writers.Rule, message string) {
+		_, err := writers.NewChain(rule)
+		Expect(err).To(MatchError(ContainSubstring(message)))
+	},
+		Entry("unknown action", writers.Rule{Action: "explode"}, `rule 0: unknown action "explode"`),
+		Entry("unknown field", writers.Rule{Action: writers.ActionDelete, Field: "body"}, `unknown field "body"`),
+		Entry("missing field", writers.Rule{Action: writers.ActionSet, Value: "a"}, "set needs a field"),
+		Entry("bad severity", writers.Rule{Action: writers.ActionSet, Field: "severity", Value: "9"}, `"9" is not between 0 and 7`),
+		Entry("element as target", writers.Rule{Action: writers.ActionRename, Field: "procid", To: "sd.meta"}, "sd.meta needs a param name"),
+		Entry("hostname with a space", writers.Rule{Action: writers.ActionSet, Field: "hostname", Value: "web 1"}, `hostname "web 1" has ' '`),
+		Entry("appname too long", writers.Rule{Action: writers.ActionSet, Field: "appname", Value: strings.Repeat("a", 60)}, "longer than 48 bytes"),
+		Entry("procid too long", writers.Rule{Action: writers.ActionSet, Field: "procid", Value: strings.Repeat("1", 129)}, "longer than 128 bytes"),
+		Entry("msgid too long", writers.Rule{Action: writers.ActionSet, Field: "msgid", Value: strings.Repeat("a", 33)}, "longer than 32 bytes"),
+		Entry("element id with a space", writers.Rule{Action: writers.ActionSet, Field: "sd.my site.a", Value: "b"}, `has ' '`),
+		Entry("param name with a quote", writers.Rule{Action: writers.ActionDelete, Field: `sd.meta.a"b`}, `has '"'`),
+		Entry("added id with a space", writers.Rule{Action: writers.ActionAdd, ID: "my site", Params: map[string]string{"a": "b"}}, `has ' '`),
+		Entry("added param with an equals", writers.Rule{Action: writers.ActionAdd, ID: "enrich", Params: map[string]string{"a=b": "c"}}, `has '='`),
+		Entry("extracted into an id with a bracket", writers.Rule{Action: writers.ActionExtract, Pattern: `(?P<user>\w+)`, ID: "enrich]"}, `has ']'`),
+		Entry("extracted group too long", writers.Rule{Action: writers.ActionExtract, Pattern: `(?P<` + strings.Repeat("a", 33) + `>\w+)`, ID: "enrich"}, "longer than 32 bytes"),
+		Entry("bad pattern", writers.Rule{Action: writers.ActionReplace, Pattern: "("}, "missing closing )"),
+		Entry("extract without id", writers.Rule{Action: writers.ActionExtract, Pattern: "(?P<a>.)"}, "extract needs an id"),
+	)
+
+	It("keeps logs parseable when a rule makes a header invalid", func() {
+		chain, err := writers.NewChain(
+			writers.Rule{Action: writers.ActionReplace, Field: "hostname", Pattern: `-`, Replacement: " "},
+			writers.Rule{Action: writers.ActionReplace, Field: "appname", Pattern: `.*`, Replacement: strings.Repeat("x", 60)},
+			writers.Rule{Action: writers.ActionRename, Field: "sd.meta.region", To: "msgid"},
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		l := original()
+		l.SetParam("meta", "region", "us east")
+		Expect(chain.Process(l)).To(BeTrue())
+
+		parsed, _, err := syslog.Parse([]byte(l.String()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Hostname()).To(Equal("WEB_1.Example.com"))
+		Expect(parsed.Appname()).To(Equal(strings.Repeat("x", 48)))
+		// the value can't be a msgid, so it stays where it was
+		Expect(parsed.MsgID()).To(BeEmpty())
+		region, _ := parsed.Param("meta", "region")
+		Expect(region).To(Equal("us east"))
+	})
+
+	It("loads rules from a file and applies them in order", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "rules.json")
+		Expect(os.WriteFile(path, []byte(`[
+			{"action": "drop", "match": {"severities": [7]}},
+			{"action": "lower", "field": "hostname"},
+			{"action": "replace", "field": "hostname", "pattern": "\\.example\\.com$"},
+			{"action": "replace", "pattern": "password=\\S+", "replacement": "password=***"},
+			{"action": "add", "id": "enrich", "params": {"site": "dc1"}},
+			{"action": "set", "field": "sd.enrich.tier", "value": "frontend", "match": {"hostnames": ["web-*"]}}
+		]`), 0600)).To(Succeed())
+
+		chain, err := writers.LoadChain(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		spy := &SpyWriter{}
+		rewriter := writers.NewRewriter(spy, chain)
+		Expect(rewriter.WriteBatch(context.Background(), []*syslog.Log{
+			original(),
+			message("noise", func(l *syslog.Log) { l.SetFacilitySeverity(1, 7) }),
+		})).To(Succeed())
+
+		Expect(spy.logs).To(HaveLen(1))
+		Expect(spy.logs[0].String()).To(Equal(
+			`<14>1 - web-1 nginx 123 - [meta region="us-east"][enrich site="dc1" tier="frontend"] user=bob password=*** logged in`,
+		))
+	})
+
+	It("reports a file it can't parse", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "rules.json")
+		Expect(os.WriteFile(path, []byte(`{"action": "drop"}`), 0600)).To(Succeed())
+
+		_, err := writers.LoadChain(path)
+		Expect(err).To(MatchError(ContainSubstring("could not parse rules")))
+	})
+})
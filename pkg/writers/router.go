@@ -0,0 +1,102 @@
+package writers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+)
+
+type Route struct {
+	Match  Match
+	Writer transports.Writer
+}
+
+// Router writes each log to the writer of the first route that matches
+// it, or to the fallback when none do. Logs are dropped when nothing
+// matches and there is no fallback. Combine with Multi to send a route to
+// several sinks.
+type Router struct {
+	routes   []Route
+	sinks    []transports.Writer
+	writers  []transports.BatchWriter
+	fallback int
+}
+
+var _ transports.BatchWriter = &Router{}
+
+// NewRouter takes a nil fallback to drop unmatched logs.
+func NewRouter(routes []Route, fallback transports.Writer) *Router {
+	r := &Router{
+		routes:   routes,
+		fallback: -1,
+	}
+	for _, route := range routes {
+		r.sinks = append(r.sinks, route.Writer)
+	}
+	if fallback != nil {
+		r.fallback = len(r.sinks)
+		r.sinks = append(r.sinks, fallback)
+	}
+	for _, sink := range r.sinks {
+		r.writers = append(r.writers, transports.NewBatchWriter(sink))
+	}
+	return r
+}
+
+// route returns the index of the writer for l, -1 when it is dropped.
+func (r *Router) route(l *syslog.Log) int {
+	for i, route := range r.routes {
+		if route.Match.Matches(l) {
+			return i
+		}
+	}
+	return r.fallback
+}
+
+func (r *Router) Write(l *syslog.Log) error {
+	i := r.route(l)
+	if i < 0 {
+		return nil
+	}
+	return r.sinks[i].Write(l)
+}
+
+// WriteBatch splits the batch by route, keeping the order of logs within
+// each route, and writes each part as a batch.
+func (r *Router) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	envelopes := transports.EnvelopesFromContext(ctx)
+	batches := make([]batch, len(r.writers))
+	for i, l := range logs {
+		if route := r.route(l); route >= 0 {
+			batches[route].add(l, envelopes, i)
+		}
+	}
+
+	var errs []error
+	for i := range batches {
+		err := batches[i].write(ctx, r.writers[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Router) Flush(ctx context.Context) error {
+	var errs []error
+	for _, w := range r.writers {
+		errs = append(errs, w.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Router) Close() error {
+	var errs []error
+	for _, w := range r.writers {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
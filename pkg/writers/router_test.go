@@ -0,0 +1,106 @@
+package writers_test
+
+import (
+	"context"
+	"net"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	"github.com/jtarchie/syslog/pkg/writers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	DescribeTable("matching", func(match writers.Match, expected bool) {
+		l := message("hello", func(l *syslog.Log) {
+			l.SetFacilitySeverity(4, 3)
+			l.AddStructureElement("origin", syslog.Property{Key: "site", Value: "dc1"})
+		})
+		Expect(match.Matches(l)).To(Equal(expected))
+	},
+		Entry("everything", writers.Match{}, true),
+		Entry("facility", writers.Match{Facilities: []int{4, 10}}, true),
+		Entry("other facility", writers.Match{Facilities: []int{10}}, false),
+		Entry("severity", writers.Match{Severities: []int{0, 1, 2, 3}}, true),
+		Entry("other severity", writers.Match{Severities: []int{6}}, false),
+		Entry("hostname glob", writers.Match{Hostnames: []string{"db-*", "web-*"}}, true),
+		Entry("other hostname", writers.Match{Hostnames: []string{"db-*"}}, false),
+		Entry("appname", writers.Match{Appnames: []string{"nginx"}}, true),
+		Entry("other appname", writers.Match{Appnames: []string{"postgres"}}, false),
+		Entry("param present", writers.Match{Params: []writers.Param{{ID: "origin", Name: "site"}}}, true),
+		Entry("param value", writers.Match{Params: []writers.Param{{ID: "origin", Name: "site", Value: "dc*"}}}, true),
+		Entry("other param value", writers.Match{Params: []writers.Param{{ID: "origin", Name: "site", Value: "dc2"}}}, false),
+		Entry("param under other id", writers.Match{Params: []writers.Param{{ID: "meta", Name: "site"}}}, false),
+		Entry("all fields", writers.Match{Facilities: []int{4}, Appnames: []string{"nginx"}}, true),
+		Entry("one field missing", writers.Match{Facilities: []int{4}, Appnames: []string{"postgres"}}, false),
+	)
+
+	It("writes to the first matching route, then the fallback", func() {
+		errors, auth, fallback := &SpyWriter{}, &SpyWriter{}, &SpyWriter{}
+		router := writers.NewRouter([]writers.Route{
+			{Match: writers.Match{Severities: []int{0, 1, 2, 3}}, Writer: errors},
+			{Match: writers.Match{Facilities: []int{4}}, Writer: auth},
+		}, fallback)
+
+		critical := func(l *syslog.Log) { l.SetFacilitySeverity(4, 2) }
+		login := func(l *syslog.Log) { l.SetFacilitySeverity(4, 6) }
+
+		Expect(router.Write(message("crash", critical))).To(Succeed())
+		Expect(router.WriteBatch(context.Background(), []*syslog.Log{
+			message("login", login),
+			message("request"),
+			message("panic", critical),
+			message("logout", login),
+		})).To(Succeed())
+
+		Expect(errors.Messages()).To(Equal([]string{"crash", "panic"}))
+		Expect(auth.Messages()).To(Equal([]string{"login", "logout"}))
+		Expect(fallback.Messages()).To(Equal([]string{"request"}))
+	})
+
+	It("drops unmatched logs without a fallback", func() {
+		matched := &SpyWriter{}
+		router := writers.NewRouter([]writers.Route{
+			{Match: writers.Match{Appnames: []string{"sshd"}}, Writer: matched},
+		}, nil)
+
+		Expect(router.Write(message("request"))).To(Succeed())
+		Expect(matched.Messages()).To(BeEmpty())
+	})
+
+	It("keeps each log's envelope when splitting a batch", func() {
+		sshd, fallback := &SpyWriter{}, &SpyWriter{}
+		router := writers.NewRouter([]writers.Route{
+			{Match: writers.Match{Appnames: []string{"sshd"}}, Writer: sshd},
+		}, fallback)
+
+		peer := func(port int) transports.Envelope {
+			return transports.Envelope{Peer: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}}
+		}
+		ctx := transports.WithEnvelopes(context.Background(), []transports.Envelope{peer(1), peer(2), peer(3)})
+
+		Expect(router.WriteBatch(ctx, []*syslog.Log{
+			message("request"),
+			message("login", func(l *syslog.Log) { l.SetAppname("sshd") }),
+			message("response"),
+		})).To(Succeed())
+
+		Expect(sshd.envelopes).To(Equal([]transports.Envelope{peer(2)}))
+		Expect(fallback.envelopes).To(Equal([]transports.Envelope{peer(1), peer(3)}))
+	})
+
+	It("isolates errors between routes", func() {
+		failing, fallback := &FailingWriter{}, &SpyWriter{}
+		router := writers.NewRouter([]writers.Route{
+			{Match: writers.Match{Appnames: []string{"sshd"}}, Writer: failing},
+		}, fallback)
+
+		err := router.WriteBatch(context.Background(), []*syslog.Log{
+			message("login", func(l *syslog.Log) { l.SetAppname("sshd") }),
+			message("request"),
+		})
+		Expect(err).To(MatchError("route 0: disk full"))
+		Expect(fallback.Messages()).To(Equal([]string{"request"}))
+	})
+})
@@ -0,0 +1,393 @@
+package writers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/jtarchie/syslog/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+const (
+	defaultSearchSize = 50
+	maxSearchSize     = 1000
+)
+
+// SearchParams are the parameters shared by the search page and API.
+type SearchParams struct {
+	// Query uses the bleve query string syntax. Empty matches everything.
+	Query string
+	// Start and End bound the message timestamps, End exclusive. Either
+	// can be zero for an open range.
+	Start, End time.Time
+	// Last, when set, replaces Start with this long before the search runs.
+	Last time.Duration
+	// Ascending sorts oldest first. The default is newest first.
+	Ascending bool
+	Size      int
+	From      int
+	// SearchAfter continues from the hit with these sort values, as
+	// returned in SearchResponse.SearchAfter, instead of using From.
+	SearchAfter []string
+	// Buckets is how many histogram buckets to split the time range into,
+	// roughly, as the width is rounded up. Zero skips the histogram.
+	Buckets int
+	// Filters all have to match, as well as Query.
+	Filters []Filter
+	// Facets is how many terms to count per facet. Zero skips facets.
+	Facets int
+
+	// restriction is what the requester's roles allow them to see.
+	restriction query.Query
+}
+
+// ParseSearchParams reads the q, start, end, last, sort, size, from,
+// search_after, buckets, filter and facets query parameters. filter can be
+// repeated.
+func ParseSearchParams(values url.Values) (SearchParams, error) {
+	params := SearchParams{
+		Query:   values.Get("q"),
+		Size:    defaultSearchSize,
+		Buckets: defaultBuckets,
+		Facets:  defaultFacetSize,
+	}
+
+	var err error
+	for name, t := range map[string]*time.Time{"start": &params.Start, "end": &params.End} {
+		if value := values.Get(name); value != "" {
+			*t, err = time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return params, fmt.Errorf("%s must be an RFC 3339 time: %q", name, value)
+			}
+		}
+	}
+
+	if value := values.Get("last"); value != "" {
+		if !params.Start.IsZero() {
+			return params, errors.New("last can't be used with start")
+		}
+		params.Last, err = parseLast(value)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		params.Ascending = true
+	default:
+		return params, fmt.Errorf("sort must be asc or desc: %q", values.Get("sort"))
+	}
+
+	for name, n := range map[string]*int{"size": &params.Size, "from": &params.From, "buckets": &params.Buckets, "facets": &params.Facets} {
+		if value := values.Get(name); value != "" {
+			*n, err = strconv.Atoi(value)
+			if err != nil || *n < 0 {
+				return params, fmt.Errorf("%s must be a positive number: %q", name, value)
+			}
+		}
+	}
+	params.Size = min(params.Size, maxSearchSize)
+	params.Buckets = min(params.Buckets, maxBuckets)
+	params.Facets = min(params.Facets, maxFacetSize)
+
+	for _, value := range values["filter"] {
+		filter, err := parseFilter(value)
+		if err != nil {
+			return params, err
+		}
+		params.Filters = append(params.Filters, filter)
+	}
+
+	if value := values.Get("search_after"); value != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err == nil {
+			err = json.Unmarshal(decoded, &params.SearchAfter)
+		}
+		if err != nil || len(params.SearchAfter) != 2 {
+			return params, fmt.Errorf("search_after is not a cursor from a previous search: %q", value)
+		}
+		if params.From != 0 {
+			return params, errors.New("search_after can't be used with from")
+		}
+	}
+
+	return params, nil
+}
+
+func (p SearchParams) query() query.Query {
+	var q query.Query = bleve.NewMatchAllQuery()
+	if p.Query != "" {
+		q = bleve.NewQueryStringQuery(p.Query)
+	}
+
+	conjuncts := []query.Query{q}
+	if !p.Start.IsZero() || !p.End.IsZero() {
+		inclusive := true
+		exclusive := false
+		timeRange := bleve.NewDateRangeInclusiveQuery(p.Start, p.End, &inclusive, &exclusive)
+		timeRange.SetField("timestamp")
+		conjuncts = append(conjuncts, timeRange)
+	}
+	for _, filter := range p.Filters {
+		conjuncts = append(conjuncts, filter.query())
+	}
+	if p.restriction != nil {
+		conjuncts = append(conjuncts, p.restriction)
+	}
+
+	if len(conjuncts) == 1 {
+		return q
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+func (p SearchParams) request() *bleve.SearchRequest {
+	request := bleve.NewSearchRequestOptions(p.query(), p.Size, p.From, false)
+	if p.Ascending {
+		request.SortBy([]string{"timestamp", "_id"})
+	} else {
+		request.SortBy([]string{"-timestamp", "-_id"})
+	}
+	if p.SearchAfter != nil {
+		request.SetSearchAfter(p.SearchAfter)
+	}
+	if p.Facets > 0 {
+		addFacets(request, p.Facets)
+	}
+	return request
+}
+
+// SearchResult is a page of hits, with the histogram and facets of all of
+// them when SearchParams.Buckets and Facets are set.
+type SearchResult struct {
+	*bleve.SearchResult
+	Histogram []Bucket
+	Facets    []Facet
+}
+
+// Search runs a search against the index.
+func (s *Server) Search(params SearchParams) (*SearchResult, error) {
+	now := s.now()
+	if params.Last > 0 {
+		params.Start = now.Add(-params.Last)
+	}
+
+	request := params.request()
+
+	var buckets []Bucket
+	if params.Buckets > 0 {
+		var err error
+		buckets, err = s.buckets(params, now)
+		if err != nil {
+			return nil, err
+		}
+		if len(buckets) > 0 {
+			request.AddFacet("histogram", histogramFacet(buckets))
+		}
+	}
+
+	result, err := s.index.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	count(buckets, result)
+	return &SearchResult{
+		SearchResult: result,
+		Histogram:    buckets,
+		Facets:       facets(result),
+	}, nil
+}
+
+type SDElement struct {
+	ID     string    `json:"id"`
+	Params []SDParam `json:"params"`
+}
+
+type SDParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Message is a stored message with all of its parsed fields.
+type Message struct {
+	ID             string      `json:"id"`
+	ReceivedAt     time.Time   `json:"received_at"`
+	Version        int         `json:"version"`
+	Priority       int         `json:"priority"`
+	Facility       int         `json:"facility"`
+	Severity       int         `json:"severity"`
+	Timestamp      *time.Time  `json:"timestamp"`
+	Hostname       string      `json:"hostname"`
+	Appname        string      `json:"appname"`
+	ProcID         string      `json:"procid"`
+	MsgID          string      `json:"msgid"`
+	StructuredData []SDElement `json:"structured_data"`
+	Message        string      `json:"message"`
+}
+
+func newMessage(id string, l *syslog.Log) Message {
+	m := Message{
+		ID:             id,
+		Version:        l.Version(),
+		Priority:       l.Priority(),
+		Facility:       l.Facility(),
+		Severity:       l.Severity(),
+		Hostname:       l.Hostname(),
+		Appname:        l.Appname(),
+		ProcID:         l.ProcID(),
+		MsgID:          l.MsgID(),
+		StructuredData: []SDElement{},
+		Message:        l.Message(),
+	}
+	m.ReceivedAt, _ = idTime(id)
+	if timestamp := l.Timestamp(); !timestamp.IsZero() {
+		m.Timestamp = &timestamp
+	}
+	for _, element := range l.StructureData() {
+		sd := SDElement{ID: element.ID(), Params: []SDParam{}}
+		for _, property := range element.Properties() {
+			sd.Params = append(sd.Params, SDParam{Name: property.Key, Value: property.Value})
+		}
+		m.StructuredData = append(m.StructuredData, sd)
+	}
+	return m
+}
+
+type SearchResponse struct {
+	Total uint64    `json:"total"`
+	Took  float64   `json:"took_seconds"`
+	Hits  []Message `json:"hits"`
+	// SearchAfter is the cursor for the next page, empty on the last one.
+	SearchAfter string   `json:"search_after,omitempty"`
+	Histogram   []Bucket `json:"histogram"`
+	Facets      []Facet  `json:"facets"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) searchAPI(w http.ResponseWriter, r *http.Request) {
+	params, err := ParseSearchParams(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	params.restriction = restrictionFrom(r.Context())
+
+	result, err := s.Search(params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	response := SearchResponse{
+		Total:     result.Total,
+		Took:      result.Took.Seconds(),
+		Hits:      make([]Message, 0, len(result.Hits)),
+		Histogram: append([]Bucket{}, result.Histogram...),
+		Facets:    append([]Facet{}, result.Facets...),
+	}
+	messages, err := s.lookup(result.SearchResult)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	response.Hits = append(response.Hits, messages...)
+
+	if len(result.Hits) == params.Size && params.Size > 0 {
+		cursor, _ := json.Marshal(result.Hits[len(result.Hits)-1].Sort)
+		response.SearchAfter = base64.RawURLEncoding.EncodeToString(cursor)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// lookup loads the stored messages for the hits, skipping any that have
+// since been deleted.
+func (s *Server) lookup(result *bleve.SearchResult) ([]Message, error) {
+	var messages []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messagesBucket)
+		for _, hit := range result.Hits {
+			value := bucket.Get([]byte(hit.ID))
+			if value == nil {
+				continue
+			}
+			l, _, err := syslog.Parse(value)
+			if err != nil {
+				continue
+			}
+			messages = append(messages, newMessage(hit.ID, l))
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (s *Server) messageAPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var value []byte
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		value = append(value, tx.Bucket(messagesBucket).Get([]byte(id))...)
+		return nil
+	})
+	if value != nil && !s.allowed(r.Context(), id) {
+		// the same as missing, so ids don't tell what else is stored
+		value = nil
+	}
+	if value == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{fmt.Sprintf("no message %q", id)})
+		return
+	}
+
+	l, _, err := syslog.Parse(value)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, newMessage(id, l))
+}
+
+// allowed reports whether the restriction on the request lets it see the
+// message.
+func (s *Server) allowed(ctx context.Context, id string) bool {
+	restriction := restrictionFrom(ctx)
+	if restriction == nil {
+		return true
+	}
+
+	request := bleve.NewSearchRequestOptions(
+		bleve.NewConjunctionQuery(bleve.NewDocIDQuery([]string{id}), restriction),
+		0, 0, false,
+	)
+	result, err := s.index.Search(request)
+	return err == nil && result.Total == 1
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
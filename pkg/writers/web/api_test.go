@@ -0,0 +1,164 @@
+package writers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func get(server *writers.Server, path string, body any) int {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+	if body != nil {
+		Expect(json.Unmarshal(recorder.Body.Bytes(), body)).To(Succeed())
+	}
+	return recorder.Code
+}
+
+func texts(hits []writers.Message) []string {
+	var texts []string
+	for _, hit := range hits {
+		texts = append(texts, hit.Message)
+	}
+	return texts
+}
+
+var _ = Describe("API", func() {
+	var (
+		server *writers.Server
+		start  time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		server, err = writers.NewServer(0, writers.Options{DataDir: GinkgoT().TempDir()})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		var logs []*syslog.Log
+		for i := 0; i < 5; i++ {
+			l := message(fmt.Sprintf("request %d", i))
+			l.SetTimestamp(start.Add(time.Duration(i) * time.Minute))
+			l.SetProcID(fmt.Sprint(100 + i))
+			l.AddStructureElement("origin", syslog.Property{Key: "ip", Value: fmt.Sprintf("10.0.0.%d", i)})
+			logs = append(logs, l)
+		}
+		Expect(server.WriteBatch(context.Background(), logs)).To(Succeed())
+	})
+
+	It("returns hits with every parsed field, newest first", func() {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?q=request", &response)).To(Equal(http.StatusOK))
+
+		Expect(response.Total).To(BeEquivalentTo(5))
+		Expect(texts(response.Hits)).To(Equal([]string{"request 4", "request 3", "request 2", "request 1", "request 0"}))
+		Expect(response.SearchAfter).To(BeEmpty())
+
+		hit := response.Hits[0]
+		Expect(hit.ID).To(HaveLen(26))
+		Expect(hit.ReceivedAt).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(hit.Version).To(Equal(1))
+		Expect(hit.Priority).To(Equal(14))
+		Expect(hit.Facility).To(Equal(1))
+		Expect(hit.Severity).To(Equal(6))
+		Expect(*hit.Timestamp).To(BeTemporally("==", start.Add(4*time.Minute)))
+		Expect(hit.Hostname).To(Equal("web-1"))
+		Expect(hit.Appname).To(Equal("nginx"))
+		Expect(hit.ProcID).To(Equal("104"))
+		Expect(hit.MsgID).To(BeEmpty())
+		Expect(hit.StructuredData).To(Equal([]writers.SDElement{
+			{ID: "origin", Params: []writers.SDParam{{Name: "ip", Value: "10.0.0.4"}}},
+		}))
+	})
+
+	It("matches everything without a query", func() {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search", &response)).To(Equal(http.StatusOK))
+		Expect(response.Total).To(BeEquivalentTo(5))
+	})
+
+	It("sorts and pages", func() {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?sort=asc&size=2&from=1", &response)).To(Equal(http.StatusOK))
+
+		Expect(response.Total).To(BeEquivalentTo(5))
+		Expect(texts(response.Hits)).To(Equal([]string{"request 1", "request 2"}))
+	})
+
+	It("filters by a time range", func() {
+		var response writers.SearchResponse
+		path := fmt.Sprintf("/api/search?sort=asc&start=%s&end=%s",
+			start.Add(time.Minute).Format(time.RFC3339), start.Add(3*time.Minute).Format(time.RFC3339))
+		Expect(get(server, path, &response)).To(Equal(http.StatusOK))
+
+		Expect(texts(response.Hits)).To(Equal([]string{"request 1", "request 2"}))
+	})
+
+	It("walks every page with search_after", func() {
+		var (
+			seen   []string
+			cursor string
+		)
+		for page := 0; page < 5; page++ {
+			var response writers.SearchResponse
+			Expect(get(server, "/api/search?size=2&search_after="+cursor, &response)).To(Equal(http.StatusOK))
+			seen = append(seen, texts(response.Hits)...)
+
+			cursor = response.SearchAfter
+			if cursor == "" {
+				break
+			}
+		}
+
+		Expect(seen).To(Equal([]string{"request 4", "request 3", "request 2", "request 1", "request 0"}))
+	})
+
+	DescribeTable("rejects invalid parameters", func(query, message string) {
+		var response map[string]string
+		Expect(get(server, "/api/search?"+query, &response)).To(Equal(http.StatusBadRequest))
+		Expect(response["error"]).To(ContainSubstring(message))
+	},
+		Entry("sort", "sort=sideways", "sort must be asc or desc"),
+		Entry("size", "size=-1", "size must be a positive number"),
+		Entry("start", "start=yesterday", "start must be an RFC 3339 time"),
+		Entry("cursor", "search_after=nope", "search_after is not a cursor"),
+		Entry("cursor with from", "from=2&search_after=WyJhIiwiYiJd", "search_after can't be used with from"),
+		Entry("query", "q=%22unclosed", "unterminated quote"),
+	)
+
+	It("gets a message by id", func() {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?size=1", &response)).To(Equal(http.StatusOK))
+		id := response.Hits[0].ID
+
+		var message writers.Message
+		Expect(get(server, "/api/messages/"+id, &message)).To(Equal(http.StatusOK))
+		Expect(message).To(Equal(response.Hits[0]))
+
+		var missing map[string]string
+		Expect(get(server, "/api/messages/01ARZ3NDEKTSV4RRFFQ69G5FAV", &missing)).To(Equal(http.StatusNotFound))
+		Expect(missing["error"]).To(ContainSubstring("no message"))
+	})
+
+	It("describes itself with OpenAPI", func() {
+		var description struct {
+			OpenAPI string                    `json:"openapi"`
+			Paths   map[string]map[string]any `json:"paths"`
+		}
+		Expect(get(server, "/api/openapi.json", &description)).To(Equal(http.StatusOK))
+
+		Expect(description.OpenAPI).To(HavePrefix("3."))
+		Expect(description.Paths).To(HaveKey("/api/search"))
+		Expect(description.Paths).To(HaveKey("/api/messages/{id}"))
+	})
+})
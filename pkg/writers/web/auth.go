@@ -0,0 +1,361 @@
+package writers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity is who made a request, and the roles that decide what they can
+// search.
+type Identity struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// Authenticator identifies requests that carry its kind of credentials,
+// reporting false for any others.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, bool)
+}
+
+// BearerTokens identifies requests by an "Authorization: Bearer <token>"
+// header.
+type BearerTokens map[string]Identity
+
+func (t BearerTokens) Authenticate(r *http.Request) (Identity, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Identity{}, false
+	}
+
+	// every token is compared, in constant time, so the time taken doesn't
+	// tell which was close
+	sum := sha256.Sum256([]byte(token))
+	var (
+		found   Identity
+		matched bool
+	)
+	for candidate, identity := range t {
+		candidateSum := sha256.Sum256([]byte(candidate))
+		if subtle.ConstantTimeCompare(sum[:], candidateSum[:]) == 1 {
+			found = identity
+			matched = true
+		}
+	}
+	return found, matched
+}
+
+type user struct {
+	hash  []byte
+	roles []string
+}
+
+// Users identifies requests by HTTP basic auth, checked against bcrypt
+// hashed passwords.
+type Users struct {
+	users map[string]user
+	// dummy is checked against for unknown users, so they take as long as
+	// known ones.
+	dummy []byte
+}
+
+// LoadUsers reads a file with a line per user of name:bcrypt-hash:roles,
+// where roles are comma separated. Blank lines and lines starting with #
+// are skipped.
+func LoadUsers(path string) (*Users, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open users: %w", err)
+	}
+	defer file.Close()
+
+	users := &Users{users: map[string]user{}}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("users line %d: expected name:bcrypt-hash:roles", number)
+		}
+		_, err := bcrypt.Cost([]byte(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("users line %d: %w", number, err)
+		}
+
+		u := user{hash: []byte(fields[1])}
+		if len(fields) == 3 {
+			u.roles = splitRoles(fields[2])
+		}
+		users.users[fields[0]] = u
+		if users.dummy == nil {
+			users.dummy = u.hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read users: %w", err)
+	}
+	return users, nil
+}
+
+func (u *Users) Authenticate(r *http.Request) (Identity, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, false
+	}
+
+	found, known := u.users[name]
+	if !known {
+		if u.dummy != nil {
+			_ = bcrypt.CompareHashAndPassword(u.dummy, []byte(password))
+		}
+		return Identity{}, false
+	}
+	if bcrypt.CompareHashAndPassword(found.hash, []byte(password)) != nil {
+		return Identity{}, false
+	}
+	return Identity{Name: name, Roles: found.roles}, true
+}
+
+// ProxyHeaders identifies requests by the headers a reverse proxy that
+// has already authenticated them sets. Requests from anywhere but the
+// trusted proxies are ignored, as anyone can set headers.
+type ProxyHeaders struct {
+	UserHeader string
+	// RolesHeader has the roles comma separated.
+	RolesHeader string
+	Trusted     []netip.Prefix
+}
+
+func (p ProxyHeaders) Authenticate(r *http.Request) (Identity, bool) {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return Identity{}, false
+	}
+
+	trusted := false
+	for _, prefix := range p.Trusted {
+		trusted = trusted || prefix.Contains(addr.Addr().Unmap())
+	}
+	name := r.Header.Get(p.UserHeader)
+	if !trusted || name == "" {
+		return Identity{}, false
+	}
+	return Identity{Name: name, Roles: splitRoles(r.Header.Get(p.RolesHeader))}, true
+}
+
+func splitRoles(roles string) []string {
+	var split []string
+	for _, role := range strings.Split(roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			split = append(split, role)
+		}
+	}
+	return split
+}
+
+// Role limits the messages its members can see to those matching Query,
+// in the bleve query string syntax, such as appname:billing*. An empty
+// Query allows every message.
+type Role struct {
+	Query string `json:"query"`
+}
+
+// Auth requires every request, apart from static assets, to be identified
+// by one of the Authenticators, in order, and to have a role in Roles.
+// With several roles, messages allowed by any of them can be seen.
+type Auth struct {
+	Authenticators []Authenticator
+	Roles          map[string]Role
+}
+
+type authConfig struct {
+	Tokens    BearerTokens `json:"tokens"`
+	UsersFile string       `json:"users_file"`
+	Proxy     *struct {
+		UserHeader  string   `json:"user_header"`
+		RolesHeader string   `json:"roles_header"`
+		Trusted     []string `json:"trusted"`
+	} `json:"proxy"`
+	Roles map[string]Role `json:"roles"`
+}
+
+// LoadAuth reads Auth from a JSON file, as:
+//
+//	{
+//	  "tokens": {"<token>": {"name": "ci", "roles": ["admin"]}},
+//	  "users_file": "users.txt",
+//	  "proxy": {"user_header": "X-Forwarded-User", "roles_header": "X-Forwarded-Groups", "trusted": ["10.0.0.0/8"]},
+//	  "roles": {"admin": {"query": ""}, "billing": {"query": "appname:billing*"}}
+//	}
+//
+// Every authenticator is optional. users_file is relative to the file and
+// read with LoadUsers.
+func LoadAuth(path string) (*Auth, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read auth: %w", err)
+	}
+
+	var config authConfig
+	err = json.Unmarshal(contents, &config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse auth: %w", err)
+	}
+
+	auth := &Auth{Roles: config.Roles}
+	if len(config.Tokens) > 0 {
+		auth.Authenticators = append(auth.Authenticators, config.Tokens)
+	}
+	if config.UsersFile != "" {
+		usersFile := config.UsersFile
+		if !filepath.IsAbs(usersFile) {
+			usersFile = filepath.Join(filepath.Dir(path), usersFile)
+		}
+		users, err := LoadUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.Authenticators = append(auth.Authenticators, users)
+	}
+	if config.Proxy != nil {
+		if config.Proxy.UserHeader == "" {
+			return nil, fmt.Errorf("proxy needs a user_header")
+		}
+		proxy := ProxyHeaders{
+			UserHeader:  config.Proxy.UserHeader,
+			RolesHeader: config.Proxy.RolesHeader,
+		}
+		for _, trusted := range config.Proxy.Trusted {
+			prefix, err := netip.ParsePrefix(trusted)
+			if err != nil {
+				return nil, fmt.Errorf("proxy trusted %q: %w", trusted, err)
+			}
+			proxy.Trusted = append(proxy.Trusted, prefix)
+		}
+		auth.Authenticators = append(auth.Authenticators, proxy)
+	}
+	return auth, nil
+}
+
+// authorizer is Auth with the role queries parsed.
+type authorizer struct {
+	authenticators []Authenticator
+	// roles are nil for those with no restriction
+	roles map[string]query.Query
+	basic bool
+}
+
+func newAuthorizer(auth *Auth) (*authorizer, error) {
+	a := &authorizer{
+		authenticators: auth.Authenticators,
+		roles:          map[string]query.Query{},
+	}
+	for _, authenticator := range auth.Authenticators {
+		_, basic := authenticator.(*Users)
+		a.basic = a.basic || basic
+	}
+	for name, role := range auth.Roles {
+		if role.Query == "" {
+			a.roles[name] = nil
+			continue
+		}
+		q, err := bleve.NewQueryStringQuery(role.Query).Parse()
+		if err != nil {
+			return nil, fmt.Errorf("role %s: %w", name, err)
+		}
+		a.roles[name] = q
+	}
+	return a, nil
+}
+
+// restriction is what the identity's roles allow, nil for everything,
+// reporting false when none of the roles are known.
+func (a *authorizer) restriction(identity Identity) (query.Query, bool) {
+	var (
+		allowed  bool
+		disjunct []query.Query
+	)
+	for _, name := range identity.Roles {
+		q, ok := a.roles[name]
+		if !ok {
+			continue
+		}
+		if q == nil {
+			return nil, true
+		}
+		allowed = true
+		disjunct = append(disjunct, q)
+	}
+
+	switch {
+	case !allowed:
+		return nil, false
+	case len(disjunct) == 1:
+		return disjunct[0], true
+	default:
+		return bleve.NewDisjunctionQuery(disjunct...), true
+	}
+}
+
+type restrictionKey struct{}
+
+// restrictionFrom is the restriction authorize put on the request, nil
+// when there is none.
+func restrictionFrom(ctx context.Context) query.Query {
+	q, _ := ctx.Value(restrictionKey{}).(query.Query)
+	return q
+}
+
+// authorize identifies the request and adds its restriction, or responds
+// with 401 or 403 and reports false.
+func (a *authorizer) authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	for _, authenticator := range a.authenticators {
+		identity, ok := authenticator.Authenticate(r)
+		if !ok {
+			continue
+		}
+
+		restriction, allowed := a.restriction(identity)
+		if !allowed {
+			denied(w, r, http.StatusForbidden, fmt.Sprintf("%s has no role with access", identity.Name))
+			return nil, false
+		}
+		if restriction != nil {
+			r = r.WithContext(context.WithValue(r.Context(), restrictionKey{}, restriction))
+		}
+		return r, true
+	}
+
+	if a.basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="syslog", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	denied(w, r, http.StatusUnauthorized, "not authenticated")
+	return nil, false
+}
+
+func denied(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSON(w, status, errorResponse{message})
+		return
+	}
+	http.Error(w, message, status)
+}
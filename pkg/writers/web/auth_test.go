@@ -0,0 +1,290 @@
+package writers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = Describe("Auth", func() {
+	var (
+		server *writers.Server
+		dir    string
+		ids    map[string]string
+	)
+
+	roles := map[string]writers.Role{
+		"admin":   {},
+		"billing": {Query: "appname:billing*"},
+		"edge":    {Query: "hostname:edge-*"},
+	}
+
+	open := func(authenticators ...writers.Authenticator) {
+		var err error
+		server, err = writers.NewServer(0, writers.Options{
+			DataDir: dir,
+			Auth:    &writers.Auth{Authenticators: authenticators, Roles: roles},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		var logs []*syslog.Log
+		for _, appname := range []string{"billing-api", "billing-worker", "nginx"} {
+			l := message("request from " + appname)
+			l.SetAppname(appname)
+			logs = append(logs, l)
+		}
+		Expect(server.WriteBatch(context.Background(), logs)).To(Succeed())
+
+		ids = map[string]string{}
+		Expect(server.Messages(time.Time{}, time.Time{}, func(id string, l *syslog.Log) bool {
+			ids[l.Appname()] = id
+			return true
+		})).To(Succeed())
+	}
+
+	tokens := writers.BearerTokens{
+		"admin-token":   {Name: "ci", Roles: []string{"admin"}},
+		"billing-token": {Name: "billing-team", Roles: []string{"billing"}},
+		"both-token":    {Name: "oncall", Roles: []string{"billing", "edge"}},
+		"nobody-token":  {Name: "intern", Roles: []string{"unknown"}},
+	}
+
+	serve := func(path string, setup func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", path, nil)
+		if setup != nil {
+			setup(request)
+		}
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	search := func(setup func(*http.Request), query string) []string {
+		recorder := serve("/api/search?q="+query, setup)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var response writers.SearchResponse
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+		return texts(response.Hits)
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("requires credentials for the pages and API", func() {
+		open(tokens)
+
+		for _, path := range []string{"/", "/tail", "/api/search", "/api/tail", "/api/openapi.json", "/api/messages/" + ids["nginx"]} {
+			recorder := serve(path, nil)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized), path)
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+		}
+		Expect(serve("/api/search", nil).Body.String()).To(MatchJSON(`{"error": "not authenticated"}`))
+		Expect(serve("/api/search", bearer("wrong-token")).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(serve("/static/style.css", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("lets an unrestricted role see everything", func() {
+		open(tokens)
+
+		Expect(search(bearer("admin-token"), "request")).To(HaveLen(3))
+	})
+
+	It("limits a restricted role to its query in the API", func() {
+		open(tokens)
+
+		Expect(search(bearer("billing-token"), "request")).To(ConsistOf("request from billing-api", "request from billing-worker"))
+		Expect(search(bearer("billing-token"), "nginx")).To(BeEmpty())
+		Expect(search(bearer("billing-token"), "")).To(HaveLen(2))
+
+		recorder := serve("/api/search?q=request", bearer("billing-token"))
+		var response writers.SearchResponse
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Total).To(BeEquivalentTo(2))
+		for _, facet := range response.Facets {
+			if facet.Field == "appname" {
+				Expect(facet.Terms).To(ConsistOf(
+					writers.FacetTerm{Term: "billing-api", Count: 1},
+					writers.FacetTerm{Term: "billing-worker", Count: 1},
+				))
+			}
+		}
+	})
+
+	It("hides the messages a role can't see by id", func() {
+		open(tokens)
+
+		Expect(serve("/api/messages/"+ids["billing-api"], bearer("billing-token")).Code).To(Equal(http.StatusOK))
+
+		recorder := serve("/api/messages/"+ids["nginx"], bearer("billing-token"))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		Expect(recorder.Body.String()).To(MatchJSON(fmt.Sprintf(`{"error": "no message \"%s\""}`, ids["nginx"])))
+
+		Expect(serve("/api/messages/"+ids["nginx"], bearer("admin-token")).Code).To(Equal(http.StatusOK))
+	})
+
+	It("limits a restricted role to its query in the search page", func() {
+		open(tokens)
+
+		body := serve("/?q=request", bearer("billing-token")).Body.String()
+		Expect(body).To(ContainSubstring("request from billing-api"))
+		Expect(body).ToNot(ContainSubstring("request from nginx"))
+	})
+
+	It("limits a restricted role to its query in the live tail", func() {
+		open(tokens)
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		request, err := http.NewRequest("GET", httpServer.URL+"/api/tail", nil)
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Authorization", "Bearer billing-token")
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		nginx := message("streamed from nginx")
+		billing := message("streamed from billing")
+		billing.SetAppname("billing-api")
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{nginx, billing})).To(Succeed())
+
+		lines := bufio.NewScanner(response.Body)
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var m writers.Message
+				Expect(json.Unmarshal([]byte(data), &m)).To(Succeed())
+				Expect(m.Message).To(Equal("streamed from billing"))
+				break
+			}
+		}
+	})
+
+	It("lets several roles see what any of them can", func() {
+		open(tokens)
+
+		edge := message("request from the edge")
+		edge.SetHostname("edge-3")
+		Expect(server.Write(edge)).To(Succeed())
+
+		Expect(search(bearer("both-token"), "request")).To(ConsistOf(
+			"request from billing-api", "request from billing-worker", "request from the edge",
+		))
+	})
+
+	It("forbids identities without a known role", func() {
+		open(tokens)
+
+		recorder := serve("/api/search", bearer("nobody-token"))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"error": "intern has no role with access"}`))
+
+		recorder = serve("/", bearer("nobody-token"))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("checks basic auth against bcrypt hashed users", func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		usersFile := filepath.Join(dir, "users.txt")
+		Expect(os.WriteFile(usersFile, []byte(fmt.Sprintf("# operators\nalice:%s:billing, edge\n\nbob:%s:admin\n", hash, hash)), 0600)).To(Succeed())
+
+		users, err := writers.LoadUsers(usersFile)
+		Expect(err).ToNot(HaveOccurred())
+		open(users)
+
+		basic := func(name, password string) func(*http.Request) {
+			return func(r *http.Request) {
+				r.SetBasicAuth(name, password)
+			}
+		}
+		Expect(search(basic("alice", "hunter2"), "request")).To(HaveLen(2))
+		Expect(search(basic("bob", "hunter2"), "request")).To(HaveLen(3))
+
+		for _, setup := range []func(*http.Request){basic("alice", "wrong"), basic("carol", "hunter2"), nil} {
+			recorder := serve("/", setup)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="syslog", charset="UTF-8"`))
+		}
+	})
+
+	It("rejects users files it can't read", func() {
+		usersFile := filepath.Join(dir, "users.txt")
+		Expect(os.WriteFile(usersFile, []byte("alice:plaintext:admin\n"), 0600)).To(Succeed())
+
+		_, err := writers.LoadUsers(usersFile)
+		Expect(err).To(MatchError(ContainSubstring("users line 1")))
+	})
+
+	It("trusts proxy headers only from the trusted proxies", func() {
+		open(writers.ProxyHeaders{
+			UserHeader:  "X-Forwarded-User",
+			RolesHeader: "X-Forwarded-Groups",
+			Trusted:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		})
+
+		proxied := func(remote string) func(*http.Request) {
+			return func(r *http.Request) {
+				r.RemoteAddr = remote
+				r.Header.Set("X-Forwarded-User", "alice")
+				r.Header.Set("X-Forwarded-Groups", "billing")
+			}
+		}
+		Expect(search(proxied("10.1.2.3:5000"), "request")).To(HaveLen(2))
+		Expect(serve("/api/search", proxied("192.0.2.1:5000")).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("loads the authenticators and roles from a file", func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dir, "users.txt"), []byte(fmt.Sprintf("alice:%s:billing\n", hash)), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "auth.json"), []byte(`{
+			"tokens": {"admin-token": {"name": "ci", "roles": ["admin"]}},
+			"users_file": "users.txt",
+			"proxy": {"user_header": "X-Forwarded-User", "roles_header": "X-Forwarded-Groups", "trusted": ["127.0.0.1/32"]},
+			"roles": {"admin": {}, "billing": {"query": "appname:billing*"}}
+		}`), 0600)).To(Succeed())
+
+		auth, err := writers.LoadAuth(filepath.Join(dir, "auth.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth.Authenticators).To(HaveLen(3))
+		Expect(auth.Roles).To(Equal(map[string]writers.Role{"admin": {}, "billing": {Query: "appname:billing*"}}))
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.SetBasicAuth("alice", "hunter2")
+		identity, ok := auth.Authenticators[1].Authenticate(request)
+		Expect(ok).To(BeTrue())
+		Expect(identity).To(Equal(writers.Identity{Name: "alice", Roles: []string{"billing"}}))
+	})
+
+	It("rejects a role query it can't parse", func() {
+		_, err := writers.NewServer(0, writers.Options{
+			DataDir: dir,
+			Auth:    &writers.Auth{Roles: map[string]writers.Role{"broken": {Query: `"unclosed`}}},
+		})
+		Expect(err).To(MatchError(ContainSubstring("role broken")))
+	})
+})
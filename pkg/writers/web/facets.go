@@ -0,0 +1,101 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	defaultFacetSize = 10
+	maxFacetSize     = 100
+)
+
+// facetFields are the fields counted in facets, in the order they are
+// shown, under the names used for them in facets and filters. Facility and
+// severity are counted by name, as their numeric fields don't keep the
+// numbers as terms.
+var facetFields = []struct{ name, field string }{
+	{"hostname", "hostname"},
+	{"appname", "appname"},
+	{"facility", "facility_name"},
+	{"severity", "severity_name"},
+	{"msgid", "msgid"},
+}
+
+func facetField(name string) (string, bool) {
+	for _, f := range facetFields {
+		if f.name == name {
+			return f.field, true
+		}
+	}
+	return "", false
+}
+
+// Filter narrows a search to the messages with exactly Term in a facet's
+// Field, such as hostname web-1 or severity err.
+type Filter struct {
+	Field string
+	Term  string
+}
+
+func parseFilter(value string) (Filter, error) {
+	name, term, ok := strings.Cut(value, ":")
+	if _, known := facetField(name); !ok || !known {
+		return Filter{}, fmt.Errorf("filter must be one of hostname, appname, facility, severity or msgid, then a colon and a term: %q", value)
+	}
+	return Filter{Field: name, Term: term}, nil
+}
+
+func (f Filter) String() string {
+	return f.Field + ":" + f.Term
+}
+
+func (f Filter) query() query.Query {
+	field, _ := facetField(f.Field)
+	q := bleve.NewTermQuery(f.Term)
+	q.SetField(field)
+	return q
+}
+
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// Facet counts the hits, across all pages, with each of the most common
+// terms of a field. Other counts the hits with the rest.
+type Facet struct {
+	Field string      `json:"field"`
+	Terms []FacetTerm `json:"terms"`
+	Other int         `json:"other"`
+}
+
+func addFacets(request *bleve.SearchRequest, size int) {
+	for _, f := range facetFields {
+		request.AddFacet(f.name, bleve.NewFacetRequest(f.field, size))
+	}
+}
+
+func facets(result *bleve.SearchResult) []Facet {
+	var facets []Facet
+	for _, f := range facetFields {
+		r, ok := result.Facets[f.name]
+		if !ok {
+			continue
+		}
+
+		facet := Facet{Field: f.name, Terms: []FacetTerm{}, Other: r.Other}
+		for _, term := range r.Terms.Terms() {
+			// messages without the field, "-" on the wire, index it empty
+			if term.Term == "" {
+				continue
+			}
+			facet.Terms = append(facet.Terms, FacetTerm{Term: term.Term, Count: term.Count})
+		}
+		facets = append(facets, facet)
+	}
+	return facets
+}
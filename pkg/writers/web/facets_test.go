@@ -0,0 +1,113 @@
+package writers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Facets", func() {
+	var server *writers.Server
+
+	BeforeEach(func() {
+		var err error
+		server, err = writers.NewServer(0, writers.Options{DataDir: GinkgoT().TempDir()})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		var logs []*syslog.Log
+		for i, hostname := range []string{"web-1", "web-1", "web-1", "web-2", "web-2"} {
+			l := message(fmt.Sprintf("request %d", i))
+			l.SetHostname(hostname)
+			logs = append(logs, l)
+		}
+		query := message("slow query")
+		query.SetHostname("db-1")
+		query.SetAppname("postgres")
+		query.SetFacilitySeverity(16, 3)
+		query.SetMsgID("Q1")
+		logs = append(logs, query)
+
+		Expect(server.WriteBatch(context.Background(), logs)).To(Succeed())
+	})
+
+	find := func(query string) writers.SearchResponse {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?"+query, &response)).To(Equal(http.StatusOK))
+		return response
+	}
+
+	facet := func(response writers.SearchResponse, field string) writers.Facet {
+		for _, facet := range response.Facets {
+			if facet.Field == field {
+				return facet
+			}
+		}
+		Fail("no facet for " + field)
+		return writers.Facet{}
+	}
+
+	It("counts the most common terms of each field, across all pages", func() {
+		response := find("size=1")
+		Expect(response.Hits).To(HaveLen(1))
+
+		Expect(facet(response, "hostname").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "web-1", Count: 3}, {Term: "web-2", Count: 2}, {Term: "db-1", Count: 1},
+		}))
+		Expect(facet(response, "appname").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "nginx", Count: 5}, {Term: "postgres", Count: 1},
+		}))
+		Expect(facet(response, "facility").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "user", Count: 5}, {Term: "local0", Count: 1},
+		}))
+		Expect(facet(response, "severity").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "info", Count: 5}, {Term: "err", Count: 1},
+		}))
+		Expect(facet(response, "msgid").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "Q1", Count: 1},
+		}))
+	})
+
+	It("counts only the hits of the query", func() {
+		response := find("q=request")
+		Expect(facet(response, "hostname").Terms).To(Equal([]writers.FacetTerm{
+			{Term: "web-1", Count: 3}, {Term: "web-2", Count: 2},
+		}))
+	})
+
+	It("limits the terms counted", func() {
+		hostname := facet(find("facets=1"), "hostname")
+		Expect(hostname.Terms).To(Equal([]writers.FacetTerm{{Term: "web-1", Count: 3}}))
+		Expect(hostname.Other).To(Equal(3))
+
+		Expect(find("facets=0").Facets).To(BeEmpty())
+	})
+
+	It("narrows the search by filters", func() {
+		Expect(find("filter=hostname:web-2").Total).To(BeEquivalentTo(2))
+		Expect(texts(find("filter=severity:err").Hits)).To(Equal([]string{"slow query"}))
+		Expect(find("filter=appname:nginx&filter=hostname:web-1").Total).To(BeEquivalentTo(3))
+		Expect(find("q=request&filter=hostname:db-1").Total).To(BeEquivalentTo(0))
+	})
+
+	It("rejects filters on other fields", func() {
+		var response struct{ Error string }
+		Expect(get(server, "/api/search?filter=message:slow", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Error).To(ContainSubstring("filter must be one of"))
+	})
+
+	It("links the facets on the search page to refined searches", func() {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/?q=request&filter=appname:nginx", nil))
+		body := recorder.Body.String()
+
+		Expect(body).To(ContainSubstring(`href="/?filter=appname%3Anginx&amp;filter=hostname%3Aweb-1&amp;q=request"`))
+		Expect(body).To(ContainSubstring(`href="/?q=request">appname:nginx &times;</a>`))
+	})
+})
@@ -0,0 +1,149 @@
+package writers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/jtarchie/syslog/pkg/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultBuckets = 60
+	maxBuckets     = 500
+)
+
+// intervals are the bucket widths a histogram picks from, the smallest that
+// fits the range in the buckets asked for. Past the last one, whole days
+// are used.
+var intervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour,
+}
+
+// Bucket counts the hits with a timestamp from Start up to, but not
+// including, End.
+type Bucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+}
+
+// parseLast reads a relative range such as "15m", "last 15m", "2d" or
+// "1w".
+func parseLast(value string) (time.Duration, error) {
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "last"))
+
+	var (
+		d   time.Duration
+		err error
+	)
+	switch unit := value[max(len(value)-1, 0):]; unit {
+	case "d", "w":
+		var n int
+		n, err = strconv.Atoi(value[:len(value)-1])
+		d = time.Duration(n) * 24 * time.Hour
+		if unit == "w" {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("last must be a duration such as 15m, 24h or 7d: %q", value)
+	}
+	return d, nil
+}
+
+func interval(span time.Duration, buckets int) time.Duration {
+	width := span / time.Duration(buckets)
+	for _, i := range intervals {
+		if i >= width {
+			return i
+		}
+	}
+	day := 24 * time.Hour
+	return (width + day - 1) / day * day
+}
+
+// buckets splits the search's time range for its histogram. Open ranges
+// end now, and start at the oldest matching timestamp.
+func (s *Server) buckets(params SearchParams, now time.Time) ([]Bucket, error) {
+	start, end := params.Start, params.End
+	if end.IsZero() {
+		// the range is exclusive, and should still count messages from now
+		end = now.Add(time.Nanosecond)
+	}
+	if start.IsZero() {
+		var err error
+		start, err = s.oldest(params)
+		if err != nil || start.IsZero() {
+			return nil, err
+		}
+	}
+	if !start.Before(end) {
+		return nil, nil
+	}
+
+	width := interval(end.Sub(start), params.Buckets)
+	var buckets []Bucket
+	for t := start.Truncate(width); t.Before(end); t = t.Add(width) {
+		buckets = append(buckets, Bucket{Start: t, End: t.Add(width)})
+	}
+	return buckets, nil
+}
+
+// oldest is the earliest timestamp of the messages matching the search,
+// zero when there are none.
+func (s *Server) oldest(params SearchParams) (time.Time, error) {
+	request := bleve.NewSearchRequestOptions(params.query(), 1, 0, false)
+	request.SortBy([]string{"timestamp", "_id"})
+
+	result, err := s.index.Search(request)
+	if err != nil || len(result.Hits) == 0 {
+		return time.Time{}, err
+	}
+
+	var oldest time.Time
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(messagesBucket).Get([]byte(result.Hits[0].ID))
+		if value == nil {
+			return nil
+		}
+		l, _, err := syslog.Parse(value)
+		if err != nil {
+			return nil
+		}
+		oldest = l.Timestamp()
+		return nil
+	})
+	return oldest, err
+}
+
+func histogramFacet(buckets []Bucket) *bleve.FacetRequest {
+	facet := bleve.NewFacetRequest("timestamp", len(buckets))
+	for i, bucket := range buckets {
+		facet.AddDateTimeRange(strconv.Itoa(i), bucket.Start, bucket.End)
+	}
+	return facet
+}
+
+// count fills in the buckets from the results of histogramFacet, which
+// leaves out empty ranges.
+func count(buckets []Bucket, result *bleve.SearchResult) {
+	facet, ok := result.Facets["histogram"]
+	if !ok {
+		return
+	}
+	for _, r := range facet.DateRanges {
+		i, err := strconv.Atoi(r.Name)
+		if err == nil && i >= 0 && i < len(buckets) {
+			buckets[i].Count = r.Count
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package writers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func counts(buckets []writers.Bucket) []int {
+	var counts []int
+	for _, bucket := range buckets {
+		counts = append(counts, bucket.Count)
+	}
+	return counts
+}
+
+var _ = Describe("Time ranges", func() {
+	var (
+		server *writers.Server
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2024, 1, 1, 12, 10, 0, 0, time.UTC)
+
+		var err error
+		server, err = writers.NewServer(0, writers.Options{
+			DataDir: GinkgoT().TempDir(),
+			Now:     func() time.Time { return now },
+		})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		// every 5 minutes from 11:25 to 12:10
+		var logs []*syslog.Log
+		for i := 0; i < 10; i++ {
+			l := message(fmt.Sprintf("tick %d", i))
+			if i%2 == 1 {
+				l.SetAppname("cron")
+			}
+			l.SetTimestamp(now.Add(-time.Duration(9-i) * 5 * time.Minute))
+			logs = append(logs, l)
+		}
+		Expect(server.WriteBatch(context.Background(), logs)).To(Succeed())
+	})
+
+	find := func(query string) writers.SearchResponse {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?"+query, &response)).To(Equal(http.StatusOK))
+		return response
+	}
+
+	It("searches relative to now", func() {
+		Expect(texts(find("last=15m").Hits)).To(Equal([]string{"tick 9", "tick 8", "tick 7", "tick 6"}))
+		Expect(texts(find("last=" + url.QueryEscape("last 5m")).Hits)).To(Equal([]string{"tick 9", "tick 8"}))
+		Expect(find("last=1d").Total).To(BeEquivalentTo(10))
+	})
+
+	It("combines the range with the query", func() {
+		Expect(texts(find("q=appname:cron&last=15m").Hits)).To(Equal([]string{"tick 9", "tick 7"}))
+	})
+
+	It("rejects ranges it can't read", func() {
+		var response struct{ Error string }
+		Expect(get(server, "/api/search?last=soon", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Error).To(ContainSubstring("last must be a duration"))
+
+		Expect(get(server, "/api/search?last=15m&start=2024-01-01T00:00:00Z", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Error).To(ContainSubstring("last can't be used with start"))
+	})
+
+	It("counts the hits over the range", func() {
+		response := find("start=2024-01-01T11:30:00Z&end=2024-01-01T12:00:00Z&buckets=3&size=1")
+		Expect(response.Histogram).To(HaveLen(3))
+		Expect(response.Histogram[0].Start).To(BeTemporally("==", time.Date(2024, 1, 1, 11, 30, 0, 0, time.UTC)))
+		Expect(response.Histogram[0].End).To(BeTemporally("==", time.Date(2024, 1, 1, 11, 40, 0, 0, time.UTC)))
+		Expect(counts(response.Histogram)).To(Equal([]int{2, 2, 2}))
+
+		response = find("q=appname:cron&start=2024-01-01T11:30:00Z&end=2024-01-01T12:00:00Z&buckets=3")
+		Expect(counts(response.Histogram)).To(Equal([]int{1, 1, 1}))
+	})
+
+	It("counts an open range from the oldest hit until now", func() {
+		response := find("buckets=10")
+		Expect(response.Histogram).To(HaveLen(10))
+		Expect(response.Histogram[0].Start).To(BeTemporally("==", time.Date(2024, 1, 1, 11, 25, 0, 0, time.UTC)))
+		Expect(counts(response.Histogram)).To(Equal([]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}))
+	})
+
+	It("skips the histogram when asked to", func() {
+		Expect(find("buckets=0").Histogram).To(BeEmpty())
+		Expect(find("q=nothing").Histogram).To(BeEmpty())
+	})
+
+	It("draws the histogram on the search page", func() {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/?last=15m", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(ContainSubstring(`class="bar"`))
+		Expect(recorder.Body.String()).To(And(ContainSubstring("tick 9"), Not(ContainSubstring("tick 5"))))
+	})
+})
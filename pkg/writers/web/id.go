@@ -0,0 +1,104 @@
+package writers
+
+import (
+	"crypto/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs, which sorts the same as the
+// values it encodes.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+const idLength = 26
+
+// ids generates ULIDs: 48 bits of milliseconds then 80 bits of entropy,
+// as 26 characters. Within a millisecond the entropy is incremented rather
+// than regenerated, and a clock going backwards is ignored, so every id is
+// greater than the one before it.
+type ids struct {
+	mu      sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+func (g *ids) next(t time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(max(t.UnixMilli(), 0))
+	if ms > g.ms {
+		g.ms = ms
+		_, _ = rand.Read(g.entropy[:])
+		// leave room to increment within the millisecond
+		g.entropy[0] &= 0x7f
+	} else if !increment(g.entropy[:]) {
+		g.ms++
+		clear(g.entropy[:])
+	}
+
+	return encodeID(g.ms, g.entropy)
+}
+
+// increment adds one to a big endian number, reporting false when it
+// overflows.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// idAt is the smallest id for t, for scanning from a time.
+func idAt(t time.Time) string {
+	return encodeID(uint64(max(t.UnixMilli(), 0)), [10]byte{})
+}
+
+// idTime is the millisecond an id was generated in.
+func idTime(id string) (time.Time, bool) {
+	if len(id) != idLength {
+		return time.Time{}, false
+	}
+
+	var ms uint64
+	for _, c := range id[:10] {
+		i := strings.IndexRune(crockford, c)
+		if i < 0 {
+			return time.Time{}, false
+		}
+		ms = ms<<5 | uint64(i)
+	}
+	return time.UnixMilli(int64(ms)), true
+}
+
+func encodeID(ms uint64, entropy [10]byte) string {
+	var out [idLength]byte
+
+	// 10 characters of time, the top 2 bits always zero
+	for i := 9; i >= 0; i-- {
+		out[i] = crockford[ms&31]
+		ms >>= 5
+	}
+
+	// 16 characters of entropy, 5 bits at a time
+	var (
+		acc  uint64
+		bits uint
+	)
+	position := 10
+	for _, b := range entropy {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[position] = crockford[(acc>>bits)&31]
+			position++
+		}
+	}
+
+	return string(out[:])
+}
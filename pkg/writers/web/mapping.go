@@ -0,0 +1,125 @@
+package writers
+
+import (
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/jtarchie/syslog/pkg/log"
+)
+
+// mappingKey is kept inside the index and holds the mappingVersion it was
+// created with. An index with any other version is rebuilt from the store.
+var mappingKey = []byte("mapping")
+
+const mappingVersion = "2"
+
+var facilityNames = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = [...]string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// newIndexMapping indexes a document from newDoc as:
+//
+//	timestamp                  datetime
+//	version, priority          numeric
+//	facility, severity         numeric, for severity:<=3
+//	facility_name,
+//	severity_name              keyword, for severity_name:err
+//	hostname, appname,
+//	procid, msgid              keyword, matched exactly
+//	message                    standard analyzer
+//	sd.<id>.<param>            keyword
+func newIndexMapping() mapping.IndexMapping {
+	numeric := bleve.NewNumericFieldMapping()
+	numeric.Store = false
+	numeric.DocValues = true
+
+	keywordField := bleve.NewKeywordFieldMapping()
+	keywordField.Store = false
+	keywordField.DocValues = true
+
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = standard.Name
+	text.Store = false
+
+	timestamp := bleve.NewDateTimeFieldMapping()
+	timestamp.Store = false
+
+	sd := bleve.NewDocumentMapping()
+	sd.DefaultAnalyzer = keyword.Name
+
+	document := bleve.NewDocumentStaticMapping()
+	document.AddFieldMappingsAt("timestamp", timestamp)
+	for _, name := range []string{"version", "priority", "facility", "severity"} {
+		document.AddFieldMappingsAt(name, numeric)
+	}
+	for _, name := range []string{"facility_name", "severity_name", "hostname", "appname", "procid", "msgid"} {
+		document.AddFieldMappingsAt(name, keywordField)
+	}
+	document.AddFieldMappingsAt("message", text)
+	document.AddSubDocumentMapping("sd", sd)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = document
+	indexMapping.DefaultAnalyzer = standard.Name
+	indexMapping.StoreDynamic = false
+	return indexMapping
+}
+
+func newDoc(l *syslog.Log) map[string]any {
+	d := map[string]any{
+		"version":       l.Version(),
+		"priority":      l.Priority(),
+		"facility":      l.Facility(),
+		"severity":      l.Severity(),
+		"facility_name": name(facilityNames[:], l.Facility()),
+		"severity_name": name(severityNames[:], l.Severity()),
+		"hostname":      l.Hostname(),
+		"appname":       l.Appname(),
+		"procid":        l.ProcID(),
+		"msgid":         l.MsgID(),
+		"message":       l.Message(),
+	}
+	if timestamp := l.Timestamp(); !timestamp.IsZero() {
+		d["timestamp"] = timestamp
+	}
+
+	sd := map[string]any{}
+	for _, element := range l.StructureData() {
+		params, _ := sd[element.ID()].(map[string]any)
+		if params == nil {
+			params = map[string]any{}
+			sd[element.ID()] = params
+		}
+		for _, property := range element.Properties() {
+			// repeated params are all indexed
+			switch value := params[property.Key].(type) {
+			case nil:
+				params[property.Key] = property.Value
+			case string:
+				params[property.Key] = []string{value, property.Value}
+			case []string:
+				params[property.Key] = append(value, property.Value)
+			}
+		}
+	}
+	if len(sd) > 0 {
+		d["sd"] = sd
+	}
+	return d
+}
+
+func name(names []string, code int) string {
+	if code >= 0 && code < len(names) {
+		return names[code]
+	}
+	return strconv.Itoa(code)
+}
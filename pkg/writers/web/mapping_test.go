@@ -0,0 +1,86 @@
+package writers_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mapping", func() {
+	var server *writers.Server
+
+	BeforeEach(func() {
+		var err error
+		server, err = writers.NewServer(0, writers.Options{DataDir: GinkgoT().TempDir()})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		login := message("User Logins failed")
+		login.SetFacilitySeverity(4, 3)
+		login.SetHostname("db-1.example.com")
+		login.SetAppname("sshd")
+		login.SetMsgID("AUTH")
+		login.AddStructureElement("origin", syslog.Property{Key: "ip", Value: "10.0.0.1"})
+		login.AddStructureElement("meta@32473",
+			syslog.Property{Key: "request.id", Value: "Abc-123"},
+			syslog.Property{Key: "tag", Value: "first"},
+			syslog.Property{Key: "tag", Value: "second"},
+		)
+
+		request := message("GET /index.html")
+		request.SetHostname("web-1.example.com")
+		request.AddStructureElement("origin", syslog.Property{Key: "ip", Value: "10.0.0.2"})
+
+		debug := message("cache warm")
+		debug.SetFacilitySeverity(16, 7)
+		debug.SetHostname("db-1")
+		debug.SetAppname("sshd-keygen")
+
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{login, request, debug})).To(Succeed())
+	})
+
+	find := func(query string) []string {
+		var response writers.SearchResponse
+		Expect(get(server, "/api/search?q="+url.QueryEscape(query), &response)).To(Equal(http.StatusOK))
+		return texts(response.Hits)
+	}
+
+	It("searches structured data params as exact keywords", func() {
+		Expect(find("sd.origin.ip:10.0.0.1")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("sd.origin.ip:10.0.0.*")).To(HaveLen(2))
+		Expect(find("sd.meta@32473.request.id:Abc-123")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("sd.meta@32473.request.id:abc-123")).To(BeEmpty())
+	})
+
+	It("indexes every value of a repeated param", func() {
+		Expect(find("sd.meta@32473.tag:first")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("sd.meta@32473.tag:second")).To(Equal([]string{"User Logins failed"}))
+	})
+
+	It("searches facility and severity as numbers and names", func() {
+		Expect(find("severity:<=3")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("severity:>3")).To(HaveLen(2))
+		Expect(find("facility:>=16")).To(Equal([]string{"cache warm"}))
+		Expect(find("severity_name:err")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("facility_name:local0")).To(Equal([]string{"cache warm"}))
+	})
+
+	It("matches hostname and appname exactly", func() {
+		Expect(find("hostname:db-1")).To(Equal([]string{"cache warm"}))
+		Expect(find("hostname:db-1.example.com")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("hostname:db-1*")).To(HaveLen(2))
+		Expect(find("appname:sshd")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("msgid:AUTH")).To(Equal([]string{"User Logins failed"}))
+	})
+
+	It("analyzes the message", func() {
+		Expect(find("message:logins")).To(Equal([]string{"User Logins failed"}))
+		Expect(find("message:index.html")).To(Equal([]string{"GET /index.html"}))
+		Expect(find("warm")).To(Equal([]string{"cache warm"}))
+	})
+})
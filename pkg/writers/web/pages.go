@@ -0,0 +1,170 @@
+package writers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+var (
+	//go:embed templates
+	templateFiles embed.FS
+	//go:embed static
+	staticFiles embed.FS
+
+	searchTemplate = template.Must(template.ParseFS(templateFiles, "templates/layout.html", "templates/search.html"))
+	tailTemplate   = template.Must(template.ParseFS(templateFiles, "templates/layout.html", "templates/tail.html"))
+)
+
+// render executes the page before writing any of it, so a failure is a
+// 500 rather than half a page. Pages only load scripts and styles from
+// /static.
+func render(w http.ResponseWriter, t *template.Template, data any) {
+	var page bytes.Buffer
+	err := t.ExecuteTemplate(&page, "layout", data)
+	if err != nil {
+		log.Printf("web: could not render page: %s", err)
+		http.Error(w, "could not render page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	_, _ = w.Write(page.Bytes())
+}
+
+type bar struct {
+	Bucket
+	// Y and Height are out of 100, the height of the histogram.
+	Y, Height int
+}
+
+type link struct {
+	Text string
+	Href string
+}
+
+type facetLinks struct {
+	Field string
+	Terms []termLink
+}
+
+type termLink struct {
+	Term  string
+	Count int
+	Href  string
+}
+
+type searchPageData struct {
+	Query, Last, Start, End string
+
+	Error     string
+	Total     uint64
+	Hits      []Message
+	Histogram []bar
+	// Filters link to the search without them, and Facets to the search
+	// filtered by each term.
+	Filters []link
+	Facets  []facetLinks
+}
+
+func (s *Server) searchPage(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	data := searchPageData{
+		Query: values.Get("q"),
+		Last:  values.Get("last"),
+		Start: values.Get("start"),
+		End:   values.Get("end"),
+	}
+
+	params, err := ParseSearchParams(values)
+	if values.Get("size") == "" {
+		params.Size = maxSearchSize
+	}
+	params.restriction = restrictionFrom(r.Context())
+
+	var result *SearchResult
+	if err == nil {
+		result, err = s.Search(params)
+	}
+	if err == nil {
+		data.Hits, err = s.lookup(result.SearchResult)
+	}
+	if err != nil {
+		data.Error = err.Error()
+		render(w, searchTemplate, data)
+		return
+	}
+
+	data.Total = result.Total
+	data.Histogram = bars(result.Histogram)
+	for _, filter := range params.Filters {
+		data.Filters = append(data.Filters, link{
+			Text: filter.String(),
+			Href: refine(values, filter.String(), false),
+		})
+	}
+	for _, facet := range result.Facets {
+		if len(facet.Terms) == 0 {
+			continue
+		}
+		links := facetLinks{Field: facet.Field}
+		for _, term := range facet.Terms {
+			filter := Filter{Field: facet.Field, Term: term.Term}
+			links.Terms = append(links.Terms, termLink{
+				Term:  term.Term,
+				Count: term.Count,
+				Href:  refine(values, filter.String(), true),
+			})
+		}
+		data.Facets = append(data.Facets, links)
+	}
+
+	render(w, searchTemplate, data)
+}
+
+// bars scales the buckets to the fullest one, with none when all are
+// empty.
+func bars(buckets []Bucket) []bar {
+	most := 0
+	for _, bucket := range buckets {
+		most = max(most, bucket.Count)
+	}
+	if most == 0 {
+		return nil
+	}
+
+	bars := make([]bar, len(buckets))
+	for i, bucket := range buckets {
+		height := bucket.Count * 100 / most
+		bars[i] = bar{Bucket: bucket, Y: 100 - height, Height: height}
+	}
+	return bars
+}
+
+// refine links to the search with a filter added or removed, from its
+// first page.
+func refine(values url.Values, filter string, add bool) string {
+	refined := url.Values{}
+	for name, value := range values {
+		if name != "from" && name != "search_after" {
+			refined[name] = slices.Clone(value)
+		}
+	}
+	if add {
+		refined.Add("filter", filter)
+	} else {
+		refined["filter"] = slices.DeleteFunc(refined["filter"], func(f string) bool {
+			return f == filter
+		})
+	}
+	return "/?" + refined.Encode()
+}
+
+func (s *Server) tailPage(w http.ResponseWriter, r *http.Request) {
+	render(w, tailTemplate, struct{ Query string }{r.URL.Query().Get("q")})
+}
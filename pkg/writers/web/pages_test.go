@@ -0,0 +1,78 @@
+package writers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pages", func() {
+	var server *writers.Server
+
+	BeforeEach(func() {
+		var err error
+		server, err = writers.NewServer(0, writers.Options{DataDir: GinkgoT().TempDir()})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+	})
+
+	page := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		return recorder
+	}
+
+	It("renders a message containing a script inert", func() {
+		l := message(`attack <script>alert("message")</script>`)
+		l.SetHostname(`<script>alert(1)</script>`)
+		l.SetAppname(`<img/src=x/onerror=alert(2)>`)
+		l.AddStructureElement("meta", syslog.Property{Key: "value", Value: `"><script>alert(3)</script>`})
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{l})).To(Succeed())
+
+		body := page("/?q=attack").Body.String()
+		Expect(body).To(ContainSubstring(`<span class="message">attack &lt;script&gt;alert(&#34;message&#34;)&lt;/script&gt;</span>`))
+		Expect(body).To(ContainSubstring(`<span class="hostname">&lt;script&gt;alert(1)&lt;/script&gt;</span>`))
+		Expect(body).To(ContainSubstring(`&lt;img/src=x/onerror=alert(2)&gt;`))
+		Expect(body).To(ContainSubstring(`[meta value="&#34;&gt;&lt;script&gt;alert(3)&lt;/script&gt;"]`))
+		Expect(body).ToNot(ContainSubstring("<script>"))
+		Expect(body).ToNot(ContainSubstring("<img"))
+	})
+
+	It("escapes the query it echoes", func() {
+		query := url.QueryEscape(`"><script>alert(1)</script>`)
+
+		Expect(page("/?q=" + query).Body.String()).ToNot(ContainSubstring("<script>"))
+		Expect(page("/tail?q=" + query).Body.String()).ToNot(ContainSubstring("<script>alert"))
+	})
+
+	It("escapes errors", func() {
+		body := page("/?last=" + url.QueryEscape("<script>")).Body.String()
+		Expect(body).To(ContainSubstring(`last must be a duration such as 15m, 24h or 7d: &#34;&lt;script&gt;&#34;`))
+	})
+
+	It("only loads assets from itself", func() {
+		for _, path := range []string{"/", "/tail"} {
+			recorder := page(path)
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+			Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring("http"))
+			Expect(recorder.Body.String()).To(ContainSubstring(`<link rel="stylesheet" href="/static/style.css">`))
+		}
+	})
+
+	It("serves the embedded assets", func() {
+		Expect(page("/static/style.css").Header().Get("Content-Type")).To(Equal("text/css; charset=utf-8"))
+		Expect(page("/static/tail.js").Body.String()).To(ContainSubstring("new EventSource('/api/tail'"))
+
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/static/missing.js", nil))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,326 @@
+package writers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jtarchie/syslog/pkg/metrics"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultCompactInterval = time.Minute
+	compactBatchSize       = 1000
+	maxPriority            = 191
+)
+
+var (
+	metaBucket = []byte("meta")
+	usageKey   = []byte("usage")
+)
+
+// Retention limits the messages kept. Each set limit is enforced by
+// deleting the oldest messages first. With Facilities or Severities set,
+// the rule only counts, and deletes, messages with those.
+type Retention struct {
+	MaxAge time.Duration
+	// MaxBytes is the size of the stored messages, not of the files on
+	// disk, which bolt reuses rather than shrinks.
+	MaxBytes   int64
+	MaxCount   int
+	Facilities []int
+	Severities []int
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("age=%s bytes=%d count=%d facilities=%v severities=%v",
+		r.MaxAge, r.MaxBytes, r.MaxCount, r.Facilities, r.Severities)
+}
+
+func (r Retention) matches(value []byte) bool {
+	priority, ok := storedPriority(value)
+	return r.matchesPriority(priority, ok)
+}
+
+func (r Retention) matchesPriority(priority int, ok bool) bool {
+	if len(r.Facilities) == 0 && len(r.Severities) == 0 {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	if len(r.Facilities) > 0 && !slices.Contains(r.Facilities, priority>>3) {
+		return false
+	}
+	if len(r.Severities) > 0 && !slices.Contains(r.Severities, priority&7) {
+		return false
+	}
+	return true
+}
+
+// storedPriority reads the PRI from the start of a stored message,
+// without parsing the rest.
+func storedPriority(value []byte) (int, bool) {
+	end := bytes.IndexByte(value, '>')
+	if len(value) == 0 || value[0] != '<' || end < 2 || end > 4 {
+		return 0, false
+	}
+	priority, err := strconv.Atoi(string(value[1:end]))
+	return priority, err == nil && priority <= maxPriority
+}
+
+// usage is the count and size of the stored messages by priority, with
+// those without a valid one in the last slot. It is kept in the meta
+// bucket and updated in the same transactions as the messages, so
+// retention doesn't have to scan for it.
+type usage [maxPriority + 2]struct {
+	count int64
+	bytes int64
+}
+
+// loadUsage reads the usage from the meta bucket, or counts it from the
+// messages when a store predates it.
+func loadUsage(tx *bolt.Tx) (*usage, error) {
+	u := &usage{}
+
+	value := tx.Bucket(metaBucket).Get(usageKey)
+	if value == nil {
+		err := tx.Bucket(messagesBucket).ForEach(func(k, v []byte) error {
+			u.add(k, v, 1)
+			return nil
+		})
+		return u, err
+	}
+
+	if len(value) != len(u)*16 {
+		return nil, errors.New("stored usage is the wrong size")
+	}
+	for i := range u {
+		u[i].count = int64(binary.BigEndian.Uint64(value[i*16:]))
+		u[i].bytes = int64(binary.BigEndian.Uint64(value[i*16+8:]))
+	}
+	return u, nil
+}
+
+func (u *usage) save(tx *bolt.Tx) error {
+	value := make([]byte, 0, len(u)*16)
+	for _, slot := range u {
+		value = binary.BigEndian.AppendUint64(value, uint64(slot.count))
+		value = binary.BigEndian.AppendUint64(value, uint64(slot.bytes))
+	}
+	return tx.Bucket(metaBucket).Put(usageKey, value)
+}
+
+// add counts a message in, or with a sign of -1 out.
+func (u *usage) add(k, v []byte, sign int64) {
+	slot := len(u) - 1
+	if priority, ok := storedPriority(v); ok {
+		slot = priority
+	}
+	u[slot].count += sign
+	u[slot].bytes += sign * int64(len(k)+len(v))
+}
+
+// total is the count and size of the messages the rule applies to.
+func (u *usage) total(rule Retention) (int64, int64) {
+	var count, size int64
+	for i, slot := range u {
+		if rule.matchesPriority(i, i <= maxPriority) {
+			count += slot.count
+			size += slot.bytes
+		}
+	}
+	return count, size
+}
+
+type retentionMetrics struct {
+	deleted   *metrics.Counter
+	reclaimed *metrics.Counter
+	runs      *metrics.Histogram
+}
+
+func newRetentionMetrics(registry *metrics.Registry) *retentionMetrics {
+	return &retentionMetrics{
+		deleted: registry.Counter(
+			"syslog_retention_deleted_total",
+			"Messages deleted by retention.",
+		).With(),
+		reclaimed: registry.Counter(
+			"syslog_retention_reclaimed_bytes_total",
+			"Bytes of messages deleted by retention.",
+		).With(),
+		runs: registry.Histogram(
+			"syslog_retention_compaction_duration_seconds",
+			"Time taken to apply retention.",
+			nil,
+		).With(),
+	}
+}
+
+func (s *Server) compactor(interval time.Duration) {
+	defer close(s.compacted)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Compact()
+			if err != nil {
+				log.Printf("web: could not apply retention: %s", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Compact applies the retention rules once. It runs in the background
+// every CompactInterval, deleting compactBatchSize messages at a time so
+// writes aren't held up.
+func (s *Server) Compact() error {
+	started := time.Now()
+	defer func() {
+		s.retentionMetrics.runs.Observe(time.Since(started).Seconds())
+	}()
+
+	for _, rule := range s.retention {
+		var from []byte
+		for {
+			expired, next, err := s.expired(rule, from)
+			if err != nil {
+				return fmt.Errorf("retention %s: %w", rule, err)
+			}
+
+			err = s.delete(expired)
+			if err != nil {
+				return fmt.Errorf("retention %s: %w", rule, err)
+			}
+
+			if next == nil {
+				break
+			}
+			from = next
+		}
+	}
+	return nil
+}
+
+// expired looks at up to compactBatchSize of the oldest messages after
+// from, and returns the keys of those the rule no longer keeps. next is
+// where to carry on from, and is nil once the rule is met.
+func (s *Server) expired(rule Retention, from []byte) (expired [][]byte, next []byte, err error) {
+	var cutoff []byte
+	if rule.MaxAge > 0 {
+		cutoff = []byte(idAt(s.now().Add(-rule.MaxAge)))
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		u, err := loadUsage(tx)
+		if err != nil {
+			return err
+		}
+		count, size := u.total(rule)
+
+		cursor := tx.Bucket(messagesBucket).Cursor()
+		k, v := cursor.First()
+		if from != nil {
+			k, v = cursor.Seek(from)
+			if k != nil && bytes.Equal(k, from) {
+				k, v = cursor.Next()
+			}
+		}
+
+		var last []byte
+		for scanned := 0; k != nil && scanned < compactBatchSize; k, v = cursor.Next() {
+			old := cutoff != nil && bytes.Compare(k, cutoff) < 0
+			over := (rule.MaxCount > 0 && count > int64(rule.MaxCount)) ||
+				(rule.MaxBytes > 0 && size > rule.MaxBytes)
+			if !old && !over {
+				return nil
+			}
+
+			scanned++
+			last = append(last[:0], k...)
+			if !rule.matches(v) {
+				continue
+			}
+
+			expired = append(expired, append([]byte{}, k...))
+			count--
+			size -= int64(len(k) + len(v))
+		}
+		if k != nil {
+			next = last
+		}
+		return nil
+	})
+	return expired, next, err
+}
+
+// delete removes the keys from the index, then the store. A crash in
+// between leaves messages in the store that are no longer searchable, and
+// that the next run deletes again.
+func (s *Server) delete(keys [][]byte) error {
+	for len(keys) > 0 {
+		chunk := keys[:min(len(keys), compactBatchSize)]
+		keys = keys[len(chunk):]
+
+		deleted, reclaimed, err := s.deleteChunk(chunk)
+		if err != nil {
+			return err
+		}
+
+		s.retentionMetrics.deleted.Add(float64(deleted))
+		s.retentionMetrics.reclaimed.Add(float64(reclaimed))
+	}
+	return nil
+}
+
+// deleteChunk holds mu, so a reindex in WriteBatch can't index a message
+// between it leaving the index and the store.
+func (s *Server) deleteChunk(chunk [][]byte) (deleted, reclaimed int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := s.index.NewBatch()
+	for _, k := range chunk {
+		batch.Delete(string(k))
+	}
+	err = s.index.Batch(batch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		u, err := loadUsage(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := tx.Bucket(messagesBucket)
+		for _, k := range chunk {
+			v := bucket.Get(k)
+			if v == nil {
+				continue
+			}
+			deleted++
+			reclaimed += int64(len(k) + len(v))
+			u.add(k, v, -1)
+
+			err := bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return u.save(tx)
+	})
+	return deleted, reclaimed, err
+}
@@ -0,0 +1,181 @@
+package writers_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ = Describe("Retention", func() {
+	var (
+		now     time.Time
+		options writers.Options
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		options = writers.Options{
+			DataDir:         GinkgoT().TempDir(),
+			CompactInterval: time.Hour,
+			Now:             func() time.Time { return now },
+		}
+	})
+
+	open := func(rules ...writers.Retention) *writers.Server {
+		options.Retention = rules
+		server, err := writers.NewServer(0, options)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+		return server
+	}
+
+	write := func(server *writers.Server, texts ...string) {
+		for _, text := range texts {
+			Expect(server.Write(message("event " + text))).To(Succeed())
+			now = now.Add(time.Second)
+		}
+	}
+
+	metric := func(server *writers.Server, name string) string {
+		var output strings.Builder
+		server.Metrics().WriteTo(&output)
+		for _, line := range strings.Split(output.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, name+" "); ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	It("deletes messages older than the max age", func() {
+		server := open(writers.Retention{MaxAge: time.Hour})
+
+		write(server, "zulu", "yankee", "xray")
+		now = now.Add(30 * time.Minute)
+		write(server, "whiskey", "victor")
+		now = now.Add(45 * time.Minute)
+
+		Expect(server.Compact()).To(Succeed())
+
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event zulu"))
+		Expect(results).ToNot(ContainSubstring("event xray"))
+		Expect(results).To(ContainSubstring("event whiskey"))
+		Expect(results).To(ContainSubstring("event victor"))
+		Expect(metric(server, "syslog_retention_deleted_total")).To(Equal("3"))
+	})
+
+	It("keeps at most the max count, deleting the oldest", func() {
+		server := open(writers.Retention{MaxCount: 2})
+
+		write(server, "alpha", "bravo", "charlie", "delta")
+		Expect(server.Compact()).To(Succeed())
+
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event alpha"))
+		Expect(results).ToNot(ContainSubstring("event bravo"))
+		Expect(results).To(ContainSubstring("event charlie"))
+		Expect(results).To(ContainSubstring("event delta"))
+	})
+
+	It("keeps at most the max bytes and reports what it reclaimed", func() {
+		// keys are 26 character ids, values the serialized message
+		size := func(text string) int { return 26 + len(message(text).String()) }
+		server := open(writers.Retention{MaxBytes: int64(size("event charlie") + size("event delta"))})
+
+		write(server, "alpha", "bravo", "charlie", "delta")
+		Expect(server.Compact()).To(Succeed())
+
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event bravo"))
+		Expect(results).To(ContainSubstring("event charlie"))
+		Expect(results).To(ContainSubstring("event delta"))
+		Expect(metric(server, "syslog_retention_reclaimed_bytes_total")).To(Equal(fmt.Sprint(size("event alpha") + size("event bravo"))))
+	})
+
+	It("only counts and deletes the severities a rule is scoped to", func() {
+		server := open(writers.Retention{MaxAge: time.Hour, Severities: []int{7}})
+
+		debug := message("event debug")
+		debug.SetFacilitySeverity(1, 7)
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{debug, message("event info")})).To(Succeed())
+		now = now.Add(2 * time.Hour)
+
+		Expect(server.Compact()).To(Succeed())
+
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event debug"))
+		Expect(results).To(ContainSubstring("event info"))
+	})
+
+	It("survives a restart with the deletions applied", func() {
+		server, err := writers.NewServer(0, writers.Options{DataDir: options.DataDir, Now: options.Now, Retention: []writers.Retention{{MaxCount: 1}}})
+		Expect(err).ToNot(HaveOccurred())
+		write(server, "alpha", "bravo")
+		Expect(server.Compact()).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		server = open()
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event alpha"))
+		Expect(results).To(ContainSubstring("event bravo"))
+	})
+
+	It("deletes more than a batch in one run", func() {
+		server := open(writers.Retention{MaxCount: 10})
+
+		logs := make([]*syslog.Log, 2500)
+		for i := range logs {
+			logs[i] = message(fmt.Sprintf("event %d", i))
+		}
+		Expect(server.WriteBatch(context.Background(), logs)).To(Succeed())
+
+		Expect(server.Compact()).To(Succeed())
+		Expect(metric(server, "syslog_retention_deleted_total")).To(Equal("2490"))
+
+		Expect(server.Compact()).To(Succeed())
+		Expect(metric(server, "syslog_retention_deleted_total")).To(Equal("2490"))
+	})
+
+	It("counts a store written before usage was kept", func() {
+		server, err := writers.NewServer(0, options)
+		Expect(err).ToNot(HaveOccurred())
+		write(server, "alpha", "bravo", "charlie")
+		Expect(server.Close()).To(Succeed())
+
+		db, err := bolt.Open(filepath.Join(options.DataDir, "messages.db"), 0600, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte("meta"))
+		})).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+
+		server = open(writers.Retention{MaxCount: 1})
+		Expect(server.Compact()).To(Succeed())
+		Expect(metric(server, "syslog_retention_deleted_total")).To(Equal("2"))
+
+		results := search(server, "event")
+		Expect(results).ToNot(ContainSubstring("event bravo"))
+		Expect(results).To(ContainSubstring("event charlie"))
+	})
+
+	It("compacts in the background", func() {
+		options.CompactInterval = 10 * time.Millisecond
+		server := open(writers.Retention{MaxCount: 1})
+
+		write(server, "alpha", "bravo")
+
+		Eventually(func() string {
+			return metric(server, "syslog_retention_deleted_total")
+		}).Should(Equal("1"))
+		Expect(search(server, "event")).ToNot(ContainSubstring("event alpha"))
+	})
+})
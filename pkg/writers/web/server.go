@@ -1,161 +1,449 @@
 package writers
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"html"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"strconv"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve/v2"
-	bolt "github.com/boltdb/bolt"
 	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/metrics"
+	bolt "go.etcd.io/bbolt"
 )
 
+var (
+	messagesBucket = []byte("messages")
+	// indexedKey is kept inside the index, in the same batch as the
+	// documents, and holds the last bolt key the index has seen.
+	indexedKey = []byte("indexed")
+)
+
+const reindexBatchSize = 1000
+
+type Options struct {
+	// DataDir holds the bolt store, messages.db, and the bleve index,
+	// index.bleve, and is reopened on start. Defaults to a temporary
+	// directory that is removed on Close.
+	DataDir string
+	// Retention rules are applied every CompactInterval, which defaults to
+	// a minute. Without rules, messages are kept forever.
+	Retention       []Retention
+	CompactInterval time.Duration
+	// Now is the clock messages are stamped, and aged, by. Defaults to
+	// time.Now.
+	Now     func() time.Time
+	Metrics *metrics.Registry
+	// TailBuffer is how many messages each live tail client can fall behind
+	// by before messages are dropped for it. Defaults to 1000.
+	TailBuffer int
+	// Auth, when set, is required for every page and API.
+	Auth *Auth
+}
+
+func (o Options) withDefaults() Options {
+	if o.CompactInterval <= 0 {
+		o.CompactInterval = defaultCompactInterval
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	if o.Metrics == nil {
+		o.Metrics = metrics.NewRegistry()
+	}
+	if o.TailBuffer <= 0 {
+		o.TailBuffer = defaultTailBuffer
+	}
+	return o
+}
+
 type Server struct {
 	port       int
 	index      bleve.Index
 	httpServer *http.Server
+	mux        *http.ServeMux
 	db         *bolt.DB
-}
+	dataDir    string
+	temporary  bool
+	now        func() time.Time
+	registry   *metrics.Registry
+
+	retention        []Retention
+	retentionMetrics *retentionMetrics
+	done             chan struct{}
+	compacted        chan struct{}
+	closing          sync.Once
+	closeErr         error
 
-type doc struct {
-	Version   int
-	Priority  int
-	Timestamp time.Time
-	Hostname  string
-	AppName   string
-	ProcID    string
-	MsgID     string
-	Message   string
+	tails      *tails
+	tailBuffer int
+
+	auth *authorizer
+
+	// mu serializes writes, and retention's deletes, so the bolt keys
+	// reach the index in order.
+	mu  sync.Mutex
+	ids ids
+	// behind is set when indexing a batch failed after it was stored, and
+	// cleared once reindex has caught up. Until then indexedKey is left
+	// where it was, so a restart catches up too.
+	behind bool
 }
 
-func NewServer(port int) *Server {
-	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		log.Fatalf("could not start indexer: %s", err)
+// NewServer opens the store and index in options.DataDir. Bolt is the
+// source of truth: an index that is missing, can't be opened, was built
+// with another mapping, or is behind the store is brought up to date from
+// it.
+func NewServer(port int, options Options) (*Server, error) {
+	options = options.withDefaults()
+	s := &Server{
+		port:             port,
+		dataDir:          options.DataDir,
+		now:              options.Now,
+		registry:         options.Metrics,
+		retention:        options.Retention,
+		retentionMetrics: newRetentionMetrics(options.Metrics),
+		done:             make(chan struct{}),
+		compacted:        make(chan struct{}),
+		tails:            newTails(options.Metrics),
+		tailBuffer:       options.TailBuffer,
+	}
+
+	if options.Auth != nil {
+		var err error
+		s.auth, err = newAuthorizer(options.Auth)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	tmpFile, err := ioutil.TempFile("", "messages")
+	if s.dataDir == "" {
+		dir, err := os.MkdirTemp("", "messages")
+		if err != nil {
+			return nil, fmt.Errorf("could not create data dir: %w", err)
+		}
+		s.dataDir = dir
+		s.temporary = true
+	}
+
+	err := os.MkdirAll(s.dataDir, 0700)
 	if err != nil {
-		log.Fatalf("could not create db: %s", err)
+		return nil, fmt.Errorf("could not create data dir: %w", err)
 	}
 
-	db, err := bolt.Open(tmpFile.Name(), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	s.db, err = bolt.Open(filepath.Join(s.dataDir, "messages.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
 	if err != nil {
-		log.Fatalf("could not start db: %s", err)
+		return nil, fmt.Errorf("could not start db: %w", err)
 	}
 
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("messages"))
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messagesBucket)
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
-		return nil
+		_, err = tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		// a store from before the meta bucket is counted once, here
+		u, err := loadUsage(tx)
+		if err != nil {
+			return fmt.Errorf("load usage: %w", err)
+		}
+		return u.save(tx)
 	})
+	if err != nil {
+		s.db.Close()
+		return nil, err
+	}
 
-	return &Server{
-		index: index,
-		port:  port,
-		db:    db,
+	s.index, err = openIndex(filepath.Join(s.dataDir, "index.bleve"))
+	if err != nil {
+		s.db.Close()
+		return nil, err
 	}
+
+	err = s.reindex()
+	if err != nil {
+		s.index.Close()
+		s.db.Close()
+		return nil, fmt.Errorf("could not reindex: %w", err)
+	}
+
+	s.routes()
+	s.httpServer = &http.Server{Addr: fmt.Sprintf("localhost:%d", port), Handler: s}
+
+	if len(s.retention) > 0 {
+		go s.compactor(options.CompactInterval)
+	} else {
+		close(s.compacted)
+	}
+
+	return s, nil
 }
 
-func (s *Server) Write(l *syslog.Log) error {
-	id := strconv.FormatInt(time.Now().UnixNano(), 10)
-
-	err := s.index.Index(id, doc{
-		Version:   l.Version(),
-		Priority:  l.Priority(),
-		Timestamp: l.Timestamp(),
-		Hostname:  l.Hostname(),
-		AppName:   l.Appname(),
-		ProcID:    l.ProcID(),
-		MsgID:     l.MsgID(),
-		Message:   l.Message(),
-	})
+func openIndex(path string) (bleve.Index, error) {
+	index, err := bleve.Open(path)
+	if err == nil {
+		version, err := index.GetInternal(mappingKey)
+		if err == nil && string(version) == mappingVersion {
+			return index, nil
+		}
+		index.Close()
+		err = fmt.Errorf("mapping version %q is not %q", version, mappingVersion)
+	}
+
+	if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		log.Printf("web: rebuilding index that could not be opened: %s", err)
+		err = os.RemoveAll(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not remove index: %w", err)
+		}
+	}
 
+	index, err = bleve.New(path, newIndexMapping())
+	if err != nil {
+		return nil, fmt.Errorf("could not start indexer: %w", err)
+	}
+	err = index.SetInternal(mappingKey, []byte(mappingVersion))
+	if err != nil {
+		index.Close()
+		return nil, fmt.Errorf("could not start indexer: %w", err)
+	}
+	return index, nil
+}
+
+// reindex indexes the messages stored after the last one the index has.
+func (s *Server) reindex() error {
+	indexed, err := s.index.GetInternal(indexedKey)
 	if err != nil {
 		return err
 	}
 
-	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("messages"))
-		return bucket.Put([]byte(id), []byte(l.String()))
-	})
+	total := 0
+	for {
+		batch := s.index.NewBatch()
+		var last []byte
+
+		err = s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(messagesBucket).Cursor()
+
+			k, v := cursor.First()
+			if indexed != nil {
+				k, v = cursor.Seek(indexed)
+				if k != nil && bytes.Equal(k, indexed) {
+					k, v = cursor.Next()
+				}
+			}
+
+			for ; k != nil && batch.Size() < reindexBatchSize; k, v = cursor.Next() {
+				l, _, err := syslog.Parse(v)
+				if err != nil {
+					log.Printf("web: could not parse stored message %s: %s", k, err)
+				} else {
+					err = batch.Index(string(k), newDoc(l))
+					if err != nil {
+						return err
+					}
+				}
+				last = append(last[:0], k...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if last == nil {
+			break
+		}
+
+		batch.SetInternal(indexedKey, last)
+		err = s.index.Batch(batch)
+		if err != nil {
+			return err
+		}
+		total += batch.Size()
+		indexed = last
+	}
+
+	if total > 0 {
+		log.Printf("web: reindexed %d messages", total)
+	}
+	return nil
 }
 
-func (s *Server) Start() error {
-	log.Printf("web: starting search index")
+func (s *Server) Write(l *syslog.Log) error {
+	return s.WriteBatch(context.Background(), []*syslog.Log{l})
+}
 
-	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		html := fmt.Sprintf(`
-		<html>
-		<head>
-			<link href="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-Gn5384xqQ1aoWXA+058RXPxPg6fy4IWvTNh0E263XmFcJlSAwiGgFAW/dAiS6JXm" crossorigin="anonymous">
-		</head>
-		<body>
-		<nav class="navbar navbar-expand-kg navbar-dark bg-dark sticky-top">
-			<div class="container">
-			<a class="navbar-brand" href="/">Syslog Search</a>
-			<form class="form-inline my-md-0" type="GET" action="/">
-				<input placeholder="Search" type="search" id="q" name="q" value="%s" class="form-control">
-			</form>
-			</div>
-		</nav>
-		<div class="container">
-		`, html.EscapeString(r.URL.Query().Get("q")))
-
-		query := bleve.NewQueryStringQuery(r.URL.Query().Get("q"))
-		search := bleve.NewSearchRequest(query)
-		search.Size = 1000
-		search.SortBy([]string{"Timestamp"})
-
-		result, err := s.index.Search(search)
+// WriteBatch stores the logs in a single bolt transaction, then indexes
+// them in one bleve batch. A crash or failure in between leaves the index
+// behind, which the next WriteBatch, or NewServer, catches up on. Once
+// indexed they are sent to live tails.
+func (s *Server) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.behind {
+		err := s.reindex()
 		if err != nil {
-			html += fmt.Sprintf("Something went wrong: %s", err)
+			log.Printf("web: could not catch up the index: %s", err)
 		} else {
-			s.db.View(func(tx *bolt.Tx) error {
-				bucket := tx.Bucket([]byte("messages"))
+			s.behind = false
+		}
+	}
 
-				for _, hit := range result.Hits {
-					value := bucket.Get([]byte(hit.ID))
-					log, _, err := syslog.Parse(value)
-					if err != nil {
-						continue
-					}
+	now := s.now()
+	keys := make([]string, len(logs))
 
-					html += fmt.Sprintf(
-						`<div class="line">&lt;<span class="priority">%d</span>&gt;<span class="version">%d</span> <span class="timestamp">%s</a> <span class="hostname">%s</span> <span class="appname=">%s</span> <span class="procid">%s</span> <span class="msgid">%s</span> <span class="structured-data">%s</span> <span class="message">%s</span></div>`,
-						log.Priority(),
-						log.Version(),
-						log.Timestamp(),
-						log.Hostname(),
-						log.Appname(),
-						log.ProcID(),
-						log.MsgID(),
-						log.StructureData(),
-						log.Message(),
-					)
-				}
+	batch := s.index.NewBatch()
+	for i, l := range logs {
+		keys[i] = s.ids.next(now)
+
+		err := batch.Index(keys[i], newDoc(l))
+		if err != nil {
+			return err
+		}
+	}
+	if !s.behind {
+		batch.SetInternal(indexedKey, []byte(keys[len(keys)-1]))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		u, err := loadUsage(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := tx.Bucket(messagesBucket)
+		for i, l := range logs {
+			k, v := []byte(keys[i]), []byte(l.String())
+			err := bucket.Put(k, v)
+			if err != nil {
+				return err
+			}
+			u.add(k, v, 1)
+		}
+		return u.save(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.index.Batch(batch)
+	if err != nil {
+		s.behind = true
+		return fmt.Errorf("stored, but could not index: %w", err)
+	}
+
+	s.tails.publish(keys, logs)
+	return nil
+}
+
+// Messages calls fn, oldest first, with the messages received from from
+// up to, but not including, to, until fn returns false. A zero to means
+// no end. Messages are found by their ids, so this is the time they were
+// written, not their timestamps.
+func (s *Server) Messages(from, to time.Time, fn func(id string, l *syslog.Log) bool) error {
+	start := []byte(idAt(from))
+	var end []byte
+	if !to.IsZero() {
+		end = []byte(idAt(to))
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(messagesBucket).Cursor()
+		for k, v := cursor.Seek(start); k != nil; k, v = cursor.Next() {
+			if end != nil && bytes.Compare(k, end) >= 0 {
 				return nil
-			})
-		}
-		html += `
-		</div>
-		<script>document.getElementById('q').focus();</script>
-		</body></html>
-		`
-		w.Write([]byte(html))
+			}
+
+			l, _, err := syslog.Parse(v)
+			if err != nil {
+				log.Printf("web: could not parse stored message %s: %s", k, err)
+				continue
+			}
+			if !fn(string(k), l) {
+				return nil
+			}
+		}
+		return nil
 	})
+}
+
+func (s *Server) Flush(context.Context) error {
+	return s.db.Sync()
+}
+
+// Close stops the webserver and compaction, and closes the store and
+// index. It is safe to call more than once.
+func (s *Server) Close() error {
+	s.closing.Do(func() {
+		close(s.done)
+		<-s.compacted
 
+		s.httpServer.Close()
+
+		s.closeErr = errors.Join(s.index.Close(), s.db.Close())
+		if s.temporary {
+			s.closeErr = errors.Join(s.closeErr, os.RemoveAll(s.dataDir))
+		}
+	})
+	return s.closeErr
+}
+
+func (s *Server) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+func (s *Server) Start() error {
+	log.Printf("web: starting search index")
 	log.Printf("web: starting webserver on port %d", s.port)
-	s.httpServer = &http.Server{Addr: fmt.Sprintf("localhost:%d", s.port), Handler: mux}
-	return s.httpServer.ListenAndServe()
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
 }
 
 func (s *Server) Addr() string {
 	return s.httpServer.Addr
 }
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.auth != nil && !strings.HasPrefix(r.URL.Path, "/static/") {
+		var ok bool
+		r, ok = s.auth.authorize(w, r)
+		if !ok {
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) routes() {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /api/search", s.searchAPI)
+	s.mux.HandleFunc("GET /api/messages/{id}", s.messageAPI)
+	s.mux.HandleFunc("GET /api/tail", s.tailAPI)
+	s.mux.HandleFunc("GET /api/openapi.json", s.openAPI)
+	s.mux.HandleFunc("GET /tail", s.tailPage)
+	s.mux.Handle("GET /static/", http.FileServerFS(staticFiles))
+	s.mux.HandleFunc("GET /{$}", s.searchPage)
+}
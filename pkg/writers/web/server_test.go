@@ -0,0 +1,240 @@
+package writers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	bolt "go.etcd.io/bbolt"
+)
+
+func message(text string) *syslog.Log {
+	l := syslog.New()
+	l.SetFacilitySeverity(1, 6)
+	l.SetHostname("web-1")
+	l.SetAppname("nginx")
+	l.SetMessage(text)
+	return l
+}
+
+func search(server *writers.Server, query string) string {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/?q="+url.QueryEscape(query), nil))
+	return recorder.Body.String()
+}
+
+var _ = Describe("Server", func() {
+	var dataDir string
+
+	BeforeEach(func() {
+		dataDir = filepath.Join(GinkgoT().TempDir(), "data")
+	})
+
+	open := func() *writers.Server {
+		server, err := writers.NewServer(0, writers.Options{DataDir: dataDir})
+		Expect(err).ToNot(HaveOccurred())
+		return server
+	}
+
+	It("keeps messages across restarts", func() {
+		server := open()
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{message("first restart"), message("second restart")})).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		server = open()
+		defer server.Close()
+
+		Expect(search(server, "restart")).To(And(ContainSubstring("first restart"), ContainSubstring("second restart")))
+	})
+
+	It("rebuilds a missing index from the store", func() {
+		server := open()
+		Expect(server.Write(message("kept in bolt"))).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		Expect(os.RemoveAll(filepath.Join(dataDir, "index.bleve"))).To(Succeed())
+
+		server = open()
+		defer server.Close()
+
+		Expect(search(server, "bolt")).To(ContainSubstring("kept in bolt"))
+	})
+
+	It("rebuilds an index that can't be opened", func() {
+		server := open()
+		Expect(server.Write(message("kept in bolt"))).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(dataDir, "index.bleve", "index_meta.json"), []byte("garbage"), 0600)).To(Succeed())
+
+		server = open()
+		defer server.Close()
+
+		Expect(search(server, "bolt")).To(ContainSubstring("kept in bolt"))
+	})
+
+	It("rebuilds an index made with another mapping", func() {
+		server := open()
+		Expect(server.Write(message("kept in bolt"))).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		index, err := bleve.Open(filepath.Join(dataDir, "index.bleve"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(index.SetInternal([]byte("mapping"), []byte("1"))).To(Succeed())
+		Expect(index.Close()).To(Succeed())
+
+		server = open()
+		defer server.Close()
+
+		Expect(search(server, "hostname:web-1")).To(ContainSubstring("kept in bolt"))
+	})
+
+	It("catches up an index that is behind the store", func() {
+		server := open()
+		Expect(server.Write(message("indexed before the crash"))).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+
+		// a crash after bolt committed but before the index did
+		db, err := bolt.Open(filepath.Join(dataDir, "messages.db"), 0600, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("messages"))
+			for i := 0; i < 3; i++ {
+				err := bucket.Put([]byte(fmt.Sprintf("7ZZZZZZZZZ%016d", i)), []byte(message(fmt.Sprintf("stored only %d", i)).String()))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+
+		server = open()
+		defer server.Close()
+
+		results := search(server, "stored")
+		Expect(results).To(And(ContainSubstring("stored only 0"), ContainSubstring("stored only 2")))
+		Expect(search(server, "crash")).To(ContainSubstring("indexed before the crash"))
+	})
+
+	It("keeps every message written concurrently in the same instant, in order", func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		server, err := writers.NewServer(0, writers.Options{
+			DataDir: dataDir,
+			Now:     func() time.Time { return now },
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer server.Close()
+
+		const writers, writes, batchSize = 10, 10, 10
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for i := 0; i < writes; i++ {
+					var batch []*syslog.Log
+					for j := 0; j < batchSize; j++ {
+						batch = append(batch, message(fmt.Sprintf("writer %d write %d message %d", w, i, j)))
+					}
+					Expect(server.WriteBatch(context.Background(), batch)).To(Succeed())
+				}
+			}()
+		}
+		wg.Wait()
+
+		var (
+			ids      []string
+			messages = map[string]bool{}
+		)
+		Expect(server.Messages(now, time.Time{}, func(id string, l *syslog.Log) bool {
+			ids = append(ids, id)
+			messages[l.Message()] = true
+			return true
+		})).To(Succeed())
+
+		Expect(ids).To(HaveLen(writers * writes * batchSize))
+		Expect(messages).To(HaveLen(writers * writes * batchSize))
+		Expect(sort.StringsAreSorted(ids)).To(BeTrue())
+		Expect(slices.Compact(slices.Clone(ids))).To(HaveLen(writers * writes * batchSize))
+		for _, id := range ids {
+			Expect(id).To(MatchRegexp(`^[0-9A-HJKMNP-TV-Z]{26}$`))
+		}
+	})
+
+	It("scans messages by the time they were written", func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		server, err := writers.NewServer(0, writers.Options{
+			DataDir: dataDir,
+			Now:     func() time.Time { return now },
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer server.Close()
+
+		for i := 0; i < 5; i++ {
+			Expect(server.Write(message(fmt.Sprintf("minute %d", i)))).To(Succeed())
+			now = now.Add(time.Minute)
+		}
+
+		scan := func(from, to time.Time, limit int) []string {
+			var found []string
+			Expect(server.Messages(from, to, func(_ string, l *syslog.Log) bool {
+				found = append(found, l.Message())
+				return len(found) < limit
+			})).To(Succeed())
+			return found
+		}
+
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		Expect(scan(start.Add(time.Minute), start.Add(3*time.Minute), 10)).To(Equal([]string{"minute 1", "minute 2"}))
+		Expect(scan(start.Add(3*time.Minute), time.Time{}, 10)).To(Equal([]string{"minute 3", "minute 4"}))
+		Expect(scan(start, time.Time{}, 2)).To(Equal([]string{"minute 0", "minute 1"}))
+		Expect(scan(start.Add(-time.Hour), start, 10)).To(BeEmpty())
+	})
+
+	It("removes a temporary data dir on close", func() {
+		// other specs' servers can't leave anything in this one
+		tmp := GinkgoT().TempDir()
+		GinkgoT().Setenv("TMPDIR", tmp)
+
+		server, err := writers.NewServer(0, writers.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Write(message("gone"))).To(Succeed())
+
+		matches, _ := filepath.Glob(filepath.Join(tmp, "messages*", "messages.db"))
+		Expect(matches).To(HaveLen(1))
+
+		Expect(server.Close()).To(Succeed())
+
+		entries, err := os.ReadDir(tmp)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("stops Start on close and can be closed more than once", func() {
+		server, err := writers.NewServer(0, writers.Options{DataDir: GinkgoT().TempDir()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Addr()).To(Equal("localhost:0"))
+
+		done := make(chan error, 1)
+		go func() { done <- server.Start() }()
+
+		Expect(server.Close()).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,38 @@
+// Follows /api/tail with the query of this page, appending a line per
+// message and keeping the last 1000.
+(() => {
+	const lines = document.getElementById('lines');
+
+	const append = (text, className) => {
+		const line = document.createElement('div');
+		line.className = className;
+		line.textContent = text;
+		lines.appendChild(line);
+		if (lines.childElementCount > 1000) {
+			lines.removeChild(lines.firstChild);
+		}
+		window.scrollTo(0, document.body.scrollHeight);
+	};
+
+	const sd = (elements) => elements.length === 0 ? '-' : elements.map((element) =>
+		'[' + [element.id, ...element.params.map((param) => param.name + '="' + param.value + '"')].join(' ') + ']'
+	).join('');
+
+	const events = new EventSource('/api/tail' + window.location.search);
+	events.addEventListener('message', (event) => {
+		const m = JSON.parse(event.data);
+		append([
+			'<' + m.priority + '>' + m.version,
+			m.timestamp || '-',
+			m.hostname || '-',
+			m.appname || '-',
+			m.procid || '-',
+			m.msgid || '-',
+			sd(m.structured_data),
+			m.message,
+		].join(' '), 'line');
+	});
+	events.addEventListener('dropped', (event) => {
+		append('... ' + event.data + ' messages dropped', 'line dropped');
+	});
+})();
@@ -0,0 +1,240 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/metrics"
+)
+
+const (
+	defaultTailBuffer = 1000
+	tailKeepAlive     = 15 * time.Second
+)
+
+// ErrClosed is returned by a Subscription once it, or its Server, is
+// closed.
+var ErrClosed = errors.New("subscription closed")
+
+type tails struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+
+	clients *metrics.Gauge
+	dropped *metrics.Counter
+}
+
+func newTails(registry *metrics.Registry) *tails {
+	return &tails{
+		subscribers: map[*Subscription]struct{}{},
+		clients: registry.Gauge(
+			"syslog_tail_clients",
+			"Clients tailing messages.",
+		).With(),
+		dropped: registry.Counter(
+			"syslog_tail_dropped_total",
+			"Messages not sent to tailing clients that fell behind.",
+		).With(),
+	}
+}
+
+// publish hands the written messages to every subscriber without waiting
+// on any of them. A subscriber with a full buffer misses them.
+func (t *tails) publish(keys []string, logs []*syslog.Log) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.subscribers) == 0 {
+		return
+	}
+
+	messages := make([]Message, len(logs))
+	for i, l := range logs {
+		messages[i] = newMessage(keys[i], l)
+	}
+	for sub := range t.subscribers {
+		for _, m := range messages {
+			select {
+			case sub.buffer <- m:
+			default:
+				sub.dropped.Add(1)
+				t.dropped.Inc()
+			}
+		}
+	}
+}
+
+// Subscription receives the messages written after it was made that match
+// its query.
+type Subscription struct {
+	server  *Server
+	query   query.Query
+	buffer  chan Message
+	dropped atomic.Int64
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Subscribe starts a Subscription to the messages matching q, in the bleve
+// query string syntax. An empty q matches everything.
+func (s *Server) Subscribe(q string) (*Subscription, error) {
+	return s.subscribe(q, nil)
+}
+
+// subscribe also limits the subscription to the restriction, when there
+// is one.
+func (s *Server) subscribe(q string, restriction query.Query) (*Subscription, error) {
+	sub := &Subscription{
+		server: s,
+		buffer: make(chan Message, s.tailBuffer),
+		done:   make(chan struct{}),
+		query:  restriction,
+	}
+	if q != "" {
+		sub.query = bleve.NewQueryStringQuery(q)
+		_, err := s.index.Search(bleve.NewSearchRequestOptions(sub.query, 0, 0, false))
+		if err != nil {
+			return nil, err
+		}
+		if restriction != nil {
+			sub.query = bleve.NewConjunctionQuery(sub.query, restriction)
+		}
+	}
+
+	s.tails.mu.Lock()
+	s.tails.subscribers[sub] = struct{}{}
+	s.tails.mu.Unlock()
+	s.tails.clients.Inc()
+
+	return sub, nil
+}
+
+// Next waits for messages, returning those buffered along with how many
+// were dropped since the last call.
+func (sub *Subscription) Next(ctx context.Context) ([]Message, int, error) {
+	var messages []Message
+	select {
+	case m := <-sub.buffer:
+		messages = append(messages, m)
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case <-sub.done:
+		return nil, 0, ErrClosed
+	case <-sub.server.done:
+		return nil, 0, ErrClosed
+	}
+
+drain:
+	for len(messages) < cap(sub.buffer) {
+		select {
+		case m := <-sub.buffer:
+			messages = append(messages, m)
+		default:
+			break drain
+		}
+	}
+
+	matched, err := sub.match(messages)
+	return matched, int(sub.dropped.Swap(0)), err
+}
+
+// match keeps the messages its query finds. They are already in the index,
+// so the query is run there, limited to their ids.
+func (sub *Subscription) match(messages []Message) ([]Message, error) {
+	if sub.query == nil {
+		return messages, nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	request := bleve.NewSearchRequestOptions(
+		bleve.NewConjunctionQuery(bleve.NewDocIDQuery(ids), sub.query),
+		len(ids), 0, false,
+	)
+	result, err := sub.server.index.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(result.Hits))
+	for _, hit := range result.Hits {
+		found[hit.ID] = true
+	}
+	matched := messages[:0]
+	for _, m := range messages {
+		if found[m.ID] {
+			matched = append(matched, m)
+		}
+	}
+	return matched, nil
+}
+
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		tails := sub.server.tails
+		tails.mu.Lock()
+		delete(tails.subscribers, sub)
+		tails.mu.Unlock()
+		tails.clients.Dec()
+		close(sub.done)
+	})
+}
+
+// tailAPI streams matching messages as server-sent events: a "message"
+// event with the Message as JSON for each, and a "dropped" event with a
+// count when the client fell behind.
+func (s *Server) tailAPI(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{"streaming is not supported"})
+		return
+	}
+
+	sub, err := s.subscribe(r.URL.Query().Get("q"), restrictionFrom(r.Context()))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), tailKeepAlive)
+		messages, dropped, err := sub.Next(ctx)
+		cancel()
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case err != nil:
+			return
+		}
+
+		if dropped > 0 {
+			_, err = fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+		}
+		for _, m := range messages {
+			data, _ := json.Marshal(m)
+			_, err = fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", m.ID, data)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
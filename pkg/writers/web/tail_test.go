@@ -0,0 +1,148 @@
+package writers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	writers "github.com/jtarchie/syslog/pkg/writers/web"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tail", func() {
+	var server *writers.Server
+
+	open := func(options writers.Options) {
+		var err error
+		options.DataDir = GinkgoT().TempDir()
+		server, err = writers.NewServer(0, options)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+	}
+
+	cron := func(text string) *syslog.Log {
+		l := message(text)
+		l.SetAppname("cron")
+		return l
+	}
+
+	next := func(sub *writers.Subscription) ([]writers.Message, int) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		messages, dropped, err := sub.Next(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		return messages, dropped
+	}
+
+	It("sends the messages written after subscribing that match the query", func() {
+		open(writers.Options{})
+		Expect(server.Write(cron("before"))).To(Succeed())
+
+		sub, err := server.Subscribe("appname:cron")
+		Expect(err).ToNot(HaveOccurred())
+		defer sub.Close()
+
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{
+			cron("first"), message("skipped"), cron("second"),
+		})).To(Succeed())
+
+		messages, dropped := next(sub)
+		Expect(texts(messages)).To(Equal([]string{"first", "second"}))
+		Expect(dropped).To(Equal(0))
+	})
+
+	It("drops messages for a client that falls behind instead of blocking writes", func() {
+		open(writers.Options{TailBuffer: 2})
+
+		sub, err := server.Subscribe("")
+		Expect(err).ToNot(HaveOccurred())
+		defer sub.Close()
+
+		done := make(chan error)
+		go func() {
+			done <- server.WriteBatch(context.Background(), []*syslog.Log{
+				message("one"), message("two"), message("three"), message("four"), message("five"),
+			})
+		}()
+		Eventually(done).Should(Receive(BeNil()))
+
+		messages, dropped := next(sub)
+		Expect(texts(messages)).To(Equal([]string{"one", "two"}))
+		Expect(dropped).To(Equal(3))
+
+		Expect(server.Write(message("six"))).To(Succeed())
+		messages, dropped = next(sub)
+		Expect(texts(messages)).To(Equal([]string{"six"}))
+		Expect(dropped).To(Equal(0))
+	})
+
+	It("stops once closed", func() {
+		open(writers.Options{})
+
+		sub, err := server.Subscribe("")
+		Expect(err).ToNot(HaveOccurred())
+		sub.Close()
+
+		Expect(server.Write(message("after"))).To(Succeed())
+		_, _, err = sub.Next(context.Background())
+		Expect(err).To(MatchError(writers.ErrClosed))
+	})
+
+	It("rejects a query it can't parse", func() {
+		open(writers.Options{})
+
+		_, err := server.Subscribe(`"unclosed`)
+		Expect(err).To(HaveOccurred())
+
+		var response struct{ Error string }
+		Expect(get(server, `/api/tail?q=%22unclosed`, &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Error).To(ContainSubstring("unterminated quote"))
+	})
+
+	It("streams messages as server-sent events", func() {
+		open(writers.Options{})
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		response, err := http.Get(httpServer.URL + "/api/tail?q=appname:cron")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		Expect(server.WriteBatch(context.Background(), []*syslog.Log{message("skipped"), cron("streamed")})).To(Succeed())
+
+		lines := bufio.NewScanner(response.Body)
+		var event []string
+		for lines.Scan() && lines.Text() != "" {
+			event = append(event, lines.Text())
+		}
+		Expect(event).To(HaveLen(3))
+		Expect(event[0]).To(MatchRegexp(`^id: [0-9A-Z]{26}$`))
+		Expect(event[1]).To(Equal("event: message"))
+
+		var m writers.Message
+		Expect(json.Unmarshal([]byte(strings.TrimPrefix(event[2], "data: ")), &m)).To(Succeed())
+		Expect(m.Message).To(Equal("streamed"))
+		Expect(m.Appname).To(Equal("cron"))
+		Expect(event[0]).To(Equal("id: " + m.ID))
+	})
+
+	It("serves a page that follows the stream", func() {
+		open(writers.Options{})
+
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/tail?q=appname:cron", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(And(
+			ContainSubstring(`<script src="/static/tail.js"></script>`),
+			ContainSubstring(`value="appname:cron"`),
+		))
+	})
+})
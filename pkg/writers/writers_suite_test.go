@@ -0,0 +1,90 @@
+package writers_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"testing"
+
+	syslog "github.com/jtarchie/syslog/pkg/log"
+	"github.com/jtarchie/syslog/pkg/transports"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestWriters(t *testing.T) {
+	log.SetOutput(GinkgoWriter)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Writers Suite")
+}
+
+// SpyWriter records logs written singly and in batches, along with the
+// envelopes of each batch.
+type SpyWriter struct {
+	mu        sync.Mutex
+	logs      []*syslog.Log
+	envelopes []transports.Envelope
+	flushed   int
+	closed    int
+}
+
+func (s *SpyWriter) Write(l *syslog.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logs = append(s.logs, l)
+	return nil
+}
+
+func (s *SpyWriter) WriteBatch(ctx context.Context, logs []*syslog.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logs = append(s.logs, logs...)
+	s.envelopes = append(s.envelopes, transports.EnvelopesFromContext(ctx)...)
+	return nil
+}
+
+func (s *SpyWriter) Flush(context.Context) error {
+	s.flushed++
+	return nil
+}
+
+func (s *SpyWriter) Close() error {
+	s.closed++
+	return nil
+}
+
+func (s *SpyWriter) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []string
+	for _, l := range s.logs {
+		messages = append(messages, l.Message())
+	}
+	return messages
+}
+
+// FailingWriter only implements transports.Writer and always fails.
+type FailingWriter struct {
+	calls int
+}
+
+func (f *FailingWriter) Write(*syslog.Log) error {
+	f.calls++
+	return errors.New("disk full")
+}
+
+func message(text string, setters ...func(*syslog.Log)) *syslog.Log {
+	l := syslog.New()
+	l.SetFacilitySeverity(1, 6)
+	l.SetHostname("web-1")
+	l.SetAppname("nginx")
+	l.SetMessage(text)
+	for _, set := range setters {
+		set(l)
+	}
+	return l
+}